@@ -5,6 +5,9 @@
     sqlite
     templ
     air
+    # video, audio and avif processing, pdf thumbnails
+    ffmpeg
+    poppler_utils
   ];
 
   languages.go.enable = true;
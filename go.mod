@@ -3,11 +3,11 @@ module github.com/dominicf2001/comfychan
 go 1.23.3
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/a-h/templ v0.3.857
 	github.com/disintegration/imaging v1.6.2
 	github.com/go-chi/chi/v5 v5.2.1
 	github.com/mattn/go-sqlite3 v1.14.28
 	golang.org/x/crypto v0.37.0
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 )
-
-require golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
@@ -1,12 +1,11 @@
 package database
 
 import (
+	"cmp"
 	"database/sql"
 	"errors"
-	"io/fs"
-	"log"
-	"os"
-	"path"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/dominicf2001/comfychan/internal/util"
@@ -18,9 +17,25 @@ type Queryer interface {
 	QueryRow(query string, args ...any) *sql.Row
 }
 
+const boardColumns = `
+	id, name, slug, tag, show_poster_ids, bump_limit, image_limit,
+	banner_path, nsfw, max_threads, duplicate_window, strip_metadata,
+	allowed_media_types, spoiler_path`
+
+func scanBoard(row rowScanner) (Board, error) {
+	var b Board
+	var allowedMediaTypes string
+	err := row.Scan(
+		&b.Id, &b.Name, &b.Slug, &b.Tag, &b.ShowPosterIds, &b.BumpLimit,
+		&b.ImageLimit, &b.BannerPath, &b.Nsfw, &b.MaxThreads, &b.DuplicateWindow,
+		&b.StripMetadata, &allowedMediaTypes, &b.SpoilerPath)
+	b.AllowedMediaTypes = util.ParsePostMediaTypes(allowedMediaTypes)
+	return b, err
+}
+
 func GetBoards(db *sql.DB) ([]Board, error) {
 	rows, err := db.Query(`
-		SELECT id, name, slug, tag 
+		SELECT ` + boardColumns + `
 		FROM boards ORDER BY slug`)
 
 	if err != nil {
@@ -30,8 +45,7 @@ func GetBoards(db *sql.DB) ([]Board, error) {
 
 	var result []Board
 	for rows.Next() {
-		var b Board
-		err := rows.Scan(&b.Id, &b.Name, &b.Slug, &b.Tag)
+		b, err := scanBoard(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -43,12 +57,11 @@ func GetBoards(db *sql.DB) ([]Board, error) {
 
 func GetBoard(db *sql.DB, slug string) (Board, error) {
 	row := db.QueryRow(`
-		SELECT id, name, slug, tag 
+		SELECT `+boardColumns+`
 		FROM boards 
 		WHERE slug = ?`, slug)
 
-	var result Board
-	err := row.Scan(&result.Id, &result.Name, &result.Slug, &result.Tag)
+	result, err := scanBoard(row)
 	if err != nil {
 		return Board{}, err
 	}
@@ -56,11 +69,106 @@ func GetBoard(db *sql.DB, slug string) (Board, error) {
 	return result, row.Err()
 }
 
+func PutBoard(db *sql.DB, board Board) error {
+	_, err := db.Exec(`
+		INSERT INTO boards (
+			slug, name, tag, show_poster_ids, bump_limit, image_limit,
+			banner_path, nsfw, max_threads, duplicate_window, strip_metadata,
+			allowed_media_types, spoiler_path)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		board.Slug, board.Name, board.Tag, board.ShowPosterIds, board.BumpLimit,
+		board.ImageLimit, board.BannerPath, board.Nsfw, board.MaxThreads, board.DuplicateWindow,
+		board.StripMetadata, util.FormatPostMediaTypes(board.AllowedMediaTypes), board.SpoilerPath)
+	return err
+}
+
+// updates the board currently at slug. changing board.Slug moves its threads along
+func UpdateBoard(db *sql.DB, slug string, board Board) error {
+	_, err := db.Exec(`
+		UPDATE boards
+		SET slug = ?, name = ?, tag = ?, show_poster_ids = ?, bump_limit = ?,
+			image_limit = ?, banner_path = ?, nsfw = ?, max_threads = ?,
+			duplicate_window = ?, strip_metadata = ?, allowed_media_types = ?,
+			spoiler_path = ?
+		WHERE slug = ?`,
+		board.Slug, board.Name, board.Tag, board.ShowPosterIds, board.BumpLimit,
+		board.ImageLimit, board.BannerPath, board.Nsfw, board.MaxThreads,
+		board.DuplicateWindow, board.StripMetadata,
+		util.FormatPostMediaTypes(board.AllowedMediaTypes), board.SpoilerPath, slug)
+	return err
+}
+
+// deletes the board along with all of its threads and their media
+func DeleteBoard(db *sql.DB, slug string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	rows, err := tx.Query(`SELECT id FROM threads WHERE board_slug = ?`, slug)
+	if err != nil {
+		return err
+	}
+	var threadIds []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		threadIds = append(threadIds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var files []PostFile
+	for _, threadId := range threadIds {
+		threadFiles, err := deleteThread(tx, threadId)
+		if err != nil {
+			return err
+		}
+		files = append(files, threadFiles...)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM boards WHERE slug = ?`, slug); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// only once the posts are gone for good
+	return ReleasePostFiles(db, files)
+}
+
+const threadColumns = `
+	id, board_slug, subject, created_at, bumped_at, pinned, locked,
+	archived, archived_at`
+
+func scanThread(row rowScanner) (Thread, error) {
+	var (
+		t          Thread
+		archivedAt sql.NullTime
+	)
+	err := row.Scan(
+		&t.Id, &t.BoardSlug, &t.Subject, &t.CreatedAt, &t.BumpedAt,
+		&t.Pinned, &t.Locked, &t.Archived, &archivedAt)
+	t.ArchivedAt = archivedAt.Time
+	return t, err
+}
+
+// returns the live (not archived) threads of a board
 func GetThreads(db *sql.DB, boardSlug string) ([]Thread, error) {
 	rows, err := db.Query(`
-		SELECT id, board_slug, subject, created_at, bumped_at, pinned, locked 
+		SELECT `+threadColumns+`
 		FROM threads 
-		WHERE board_slug = ?`, boardSlug)
+		WHERE board_slug = ? AND archived = 0`, boardSlug)
 
 	if err != nil {
 		return nil, err
@@ -69,13 +177,45 @@ func GetThreads(db *sql.DB, boardSlug string) ([]Thread, error) {
 
 	var result []Thread
 	for rows.Next() {
-		var t Thread
+		t, err := scanThread(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+
+	return result, rows.Err()
+}
+
+func GetArchivedThreads(db *sql.DB, boardSlug string) ([]ArchivedThread, error) {
+	rows, err := db.Query(`
+		SELECT `+threadColumns+`,
+			(SELECT body FROM posts WHERE thread_id = threads.id ORDER BY created_at ASC LIMIT 1),
+			(SELECT COUNT(*) FROM posts WHERE thread_id = threads.id)
+		FROM threads 
+		WHERE board_slug = ? AND archived = 1
+		ORDER BY archived_at DESC`, boardSlug)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ArchivedThread
+	for rows.Next() {
+		var (
+			t          ArchivedThread
+			archivedAt sql.NullTime
+			opBody     sql.NullString
+		)
 		err := rows.Scan(
 			&t.Id, &t.BoardSlug, &t.Subject, &t.CreatedAt, &t.BumpedAt,
-			&t.Pinned, &t.Locked)
+			&t.Pinned, &t.Locked, &t.Archived, &archivedAt, &opBody, &t.ReplyCount)
 		if err != nil {
 			return nil, err
 		}
+		t.ArchivedAt = archivedAt.Time
+		t.OpBody = opBody.String
 		result = append(result, t)
 	}
 
@@ -84,14 +224,11 @@ func GetThreads(db *sql.DB, boardSlug string) ([]Thread, error) {
 
 func GetThread(db *sql.DB, threadId int) (Thread, error) {
 	row := db.QueryRow(`
-		SELECT id, board_slug, subject, created_at, bumped_at, pinned, locked 
+		SELECT `+threadColumns+`
 		FROM threads 
 		WHERE id = ?`, threadId)
 
-	var t Thread
-	err := row.Scan(
-		&t.Id, &t.BoardSlug, &t.Subject, &t.CreatedAt, &t.BumpedAt,
-		&t.Pinned, &t.Locked)
+	t, err := scanThread(row)
 	if err != nil {
 		return Thread{}, err
 	}
@@ -99,10 +236,13 @@ func GetThread(db *sql.DB, threadId int) (Thread, error) {
 	return t, row.Err()
 }
 
-func PutThread(db *sql.DB, boardSlug, subject, body, mediaPath, thumbPath, ipHash string) (int, error) {
+// creates the thread and its op. once the board is full, the least recently
+// bumped unpinned threads are archived. returns the new thread id and the
+// ids of the archived threads
+func PutThread(db *sql.DB, boardSlug, subject string, op Post) (int, []int, error) {
 	tx, err := db.Begin()
 	if err != nil {
-		return -1, err
+		return -1, nil, err
 	}
 	defer func() {
 		_ = tx.Rollback()
@@ -113,30 +253,42 @@ func PutThread(db *sql.DB, boardSlug, subject, body, mediaPath, thumbPath, ipHas
 		boardSlug, subject,
 	)
 	if err != nil {
-		return -1, err
+		return -1, nil, err
 	}
 	threadId64, err := res.LastInsertId()
 	if err != nil {
-		return -1, err
+		return -1, nil, err
 	}
 	threadId := int(threadId64)
 
-	if err := PutPost(tx, boardSlug, threadId, body, mediaPath, thumbPath, ipHash); err != nil {
-		return -1, err
+	op.ThreadId = threadId
+	if _, err := PutPost(tx, boardSlug, op); err != nil {
+		return -1, nil, err
+	}
+
+	maxThreads := util.GetConfig().BoardLimits(boardSlug).MaxThreads
+	var boardMaxThreads int
+	if err := tx.QueryRow(`SELECT max_threads FROM boards WHERE slug = ?`, boardSlug).
+		Scan(&boardMaxThreads); err != nil {
+		return -1, nil, err
+	}
+	if boardMaxThreads > 0 {
+		maxThreads = boardMaxThreads
 	}
 
 	var count int
-	if err := tx.QueryRow(`SELECT COUNT(*) FROM threads WHERE board_slug = ? AND pinned = 0`, boardSlug).
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM threads WHERE board_slug = ? AND pinned = 0 AND archived = 0`, boardSlug).
 		Scan(&count); err != nil {
-		return -1, err
+		return -1, nil, err
 	}
 
-	for count >= util.MAX_THREAD_COUNT {
+	var archivedIds []int
+	for count >= maxThreads {
 		var pruneID int
 		err := tx.QueryRow(`
             SELECT id
             FROM threads
-            WHERE board_slug = ? AND pinned = 0
+            WHERE board_slug = ? AND pinned = 0 AND archived = 0
             ORDER BY bumped_at ASC
             LIMIT 1
         `, boardSlug).Scan(&pruneID)
@@ -145,58 +297,256 @@ func PutThread(db *sql.DB, boardSlug, subject, body, mediaPath, thumbPath, ipHas
 			break
 		}
 		if err != nil {
-			return -1, err
+			return -1, nil, err
 		}
-		if err := DeleteThread(tx, pruneID); err != nil {
-			return -1, err
+		if err := ArchiveThread(tx, pruneID); err != nil {
+			return -1, nil, err
 		}
+		archivedIds = append(archivedIds, pruneID)
+
 		if err := tx.QueryRow(
-			`SELECT COUNT(*) FROM threads WHERE board_slug = ? AND pinned = 0`,
+			`SELECT COUNT(*) FROM threads WHERE board_slug = ? AND pinned = 0 AND archived = 0`,
 			boardSlug).Scan(&count); err != nil {
-			return -1, err
+			return -1, nil, err
 		}
 	}
 
 	if err := tx.Commit(); err != nil {
-		return -1, err
+		return -1, nil, err
 	}
-	return threadId, nil
+	return threadId, archivedIds, nil
 }
 
-func DeleteThread(db Queryer, threadId int) error {
-	// cleanup images
+// makes the thread read-only and hides it from the catalog. its media is
+// removed later by PurgeArchivedMedia
+func ArchiveThread(db Queryer, threadId int) error {
+	_, err := db.Exec(`
+		UPDATE threads
+		SET archived = 1, archived_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, threadId)
+	return err
+}
+
+// removes the media of threads archived longer than retention ago. a
+// negative retention keeps archived media forever
+func PurgeArchivedMedia(db *sql.DB, retention time.Duration) (int, error) {
+	if retention < 0 {
+		return 0, nil
+	}
+
 	rows, err := db.Query(`
-		SELECT media_path, thumb_path
-		FROM posts
-		WHERE thread_id = ?`, threadId)
+		SELECT `+postFileColumns+`
+		FROM post_files
+		WHERE post_id IN (
+			SELECT p.id
+			FROM posts p
+			INNER JOIN threads t ON p.thread_id = t.id
+			WHERE t.archived = 1
+				AND t.archived_at <= datetime('now', ?))`,
+		fmt.Sprintf("-%d seconds", int64(retention.Seconds())))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer rows.Close()
 
+	var files []PostFile
 	for rows.Next() {
-		var (
-			pruneMediaThumbPath string
-			pruneMediaFullPath  string
-		)
-		if err := rows.Scan(&pruneMediaFullPath, &pruneMediaThumbPath); err != nil {
+		f, err := scanPostFile(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, f := range files {
+		_, err := db.Exec(`
+			DELETE FROM post_files
+			WHERE id = ?`, f.Id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := ReleasePostFiles(db, files); err != nil {
+		return 0, err
+	}
+
+	return len(files), nil
+}
+
+// deletes the threads that were archived longer than retention ago, along
+// with their posts. a negative retention keeps them forever. returns the
+// number of deleted threads
+func DeleteArchivedThreads(db *sql.DB, retention time.Duration) (int, error) {
+	if retention < 0 {
+		return 0, nil
+	}
+
+	rows, err := db.Query(`
+		SELECT id
+		FROM threads
+		WHERE archived = 1
+			AND archived_at <= datetime('now', ?)`,
+		fmt.Sprintf("-%d seconds", int64(retention.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+
+	var threadIds []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		threadIds = append(threadIds, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range threadIds {
+		if err := DeleteThread(db, id); err != nil {
+			return i, err
+		}
+	}
+
+	return len(threadIds), nil
+}
+
+// marks the media of files that no post references anymore for
+// SweepReleasedMedia. uploads are deduplicated by hash, so a file on disk may
+// be shared by several posts. nothing is deleted right away, since an upload
+// may have matched the media just before its last post went away
+func ReleasePostFiles(db Queryer, files []PostFile) error {
+	for _, f := range files {
+		var refCount int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM post_files WHERE media_path = ?`, f.MediaPath).
+			Scan(&refCount); err != nil {
 			return err
 		}
+		if refCount > 0 {
+			continue
+		}
 
-		if pruneMediaFullPath != "" {
-			if err := os.Remove(path.Join(util.POST_MEDIA_FULL_PATH, pruneMediaFullPath)); err != nil {
-				if !errors.Is(err, fs.ErrNotExist) {
-					return err
-				}
-			}
+		_, err := db.Exec(`
+			INSERT INTO released_media (media_path, thumb_path, preview_path)
+			VALUES (?, ?, ?)
+			ON CONFLICT(media_path) DO UPDATE SET
+				thumb_path = excluded.thumb_path,
+				preview_path = excluded.preview_path,
+				released_at = CURRENT_TIMESTAMP`,
+			f.MediaPath, f.ThumbPath, f.PreviewPath)
+		if err != nil {
+			return err
 		}
-		if pruneMediaThumbPath != "" {
-			if err := os.Remove(path.Join(util.POST_MEDIA_THUMB_PATH, pruneMediaThumbPath)); err != nil {
-				if !errors.Is(err, fs.ErrNotExist) {
-					return err
-				}
-			}
+	}
+	return nil
+}
+
+// keeps released media from being swept, for an upload about to reuse or
+// overwrite it
+func ReclaimMedia(db Queryer, mediaPath string) error {
+	_, err := db.Exec(`
+		DELETE FROM released_media
+		WHERE media_path = ?`, mediaPath)
+	return err
+}
+
+// deletes media released longer than grace ago that is still unreferenced.
+// returns how many files were deleted
+func SweepReleasedMedia(db *sql.DB, grace time.Duration) (int, error) {
+	// referenced again by a post made since the release
+	_, err := db.Exec(`
+		DELETE FROM released_media
+		WHERE media_path IN (SELECT media_path FROM post_files)`)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT media_path, thumb_path, preview_path
+		FROM released_media
+		WHERE released_at < datetime('now', ?)`,
+		fmt.Sprintf("-%d seconds", int64(grace.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+
+	var files []PostFile
+	for rows.Next() {
+		var f PostFile
+		if err := rows.Scan(&f.MediaPath, &f.ThumbPath, &f.PreviewPath); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, f := range files {
+		// an upload may have reclaimed it since the query above
+		res, err := db.Exec(`
+			DELETE FROM released_media
+			WHERE media_path = ?
+				AND NOT EXISTS (SELECT 1 FROM post_files WHERE media_path = ?)`,
+			f.MediaPath, f.MediaPath)
+		if err != nil {
+			return deleted, err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			continue
 		}
+
+		if err := util.DeletePostFile(f.MediaPath, f.ThumbPath, f.PreviewPath); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func DeleteThread(db Queryer, threadId int) error {
+	files, err := deleteThread(db, threadId)
+	if err != nil {
+		return err
+	}
+
+	// cleanup images
+	return ReleasePostFiles(db, files)
+}
+
+// deletes the thread and returns its files, which the caller must release
+func deleteThread(db Queryer, threadId int) ([]PostFile, error) {
+	rows, err := db.Query(`
+		SELECT `+postFileColumns+`
+		FROM post_files
+		WHERE post_id IN (SELECT id FROM posts WHERE thread_id = ?)`, threadId)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []PostFile
+	for rows.Next() {
+		f, err := scanPostFile(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
 	// delete thread
@@ -204,16 +554,71 @@ func DeleteThread(db Queryer, threadId int) error {
 		DELETE FROM threads
 		WHERE id = ?`, threadId)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return files, nil
+}
+
+const postColumns = `
+	id, thread_id, author, tripcode, body, created_at,
+	ip_hash, number, banned, poster_id, sage, spoiler, ip_range_hash`
+
+const postFileColumns = `
+	id, post_id, position, media_path, thumb_path, sha256, phash,
+	original_name, metadata_stripped, mime_type, size, width, height, duration_ms,
+	media_status, preview_path`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPost(row rowScanner) (Post, error) {
+	var p Post
+	err := row.Scan(
+		&p.Id, &p.ThreadId, &p.Author, &p.Tripcode, &p.Body, &p.CreatedAt,
+		&p.IpHash, &p.Number, &p.Banned, &p.PosterId, &p.Sage, &p.Spoiler, &p.IpRangeHash)
+	return p, err
+}
+
+func scanPostFile(row rowScanner) (PostFile, error) {
+	var f PostFile
+	var durationMs int64
+	err := row.Scan(
+		&f.Id, &f.PostId, &f.Position, &f.MediaPath, &f.ThumbPath, &f.Sha256, &f.Phash,
+		&f.OriginalName, &f.MetadataStripped, &f.Info.MimeType, &f.Info.Size,
+		&f.Info.Width, &f.Info.Height, &durationMs, &f.MediaStatus, &f.PreviewPath)
+	f.Info.Duration = time.Duration(durationMs) * time.Millisecond
+	return f, err
+}
+
+// returns the files of postId in upload order
+func GetPostFiles(db Queryer, postId int) ([]PostFile, error) {
+	rows, err := db.Query(`
+		SELECT `+postFileColumns+`
+		FROM post_files
+		WHERE post_id = ?
+		ORDER BY position`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []PostFile
+	for rows.Next() {
+		f, err := scanPostFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+
+	return result, rows.Err()
 }
 
 func GetPosts(db *sql.DB, threadId int) ([]Post, error) {
 	rows, err := db.Query(`
-		SELECT id, thread_id, author, body, created_at, media_path, 
-			   ip_hash, number, thumb_path, banned 
+		SELECT `+postColumns+`
 		FROM posts 
 		WHERE thread_id = ?`, threadId)
 
@@ -224,130 +629,512 @@ func GetPosts(db *sql.DB, threadId int) ([]Post, error) {
 
 	var result []Post
 	for rows.Next() {
-		var p Post
-		err := rows.Scan(
-			&p.Id, &p.ThreadId, &p.Author, &p.Body, &p.CreatedAt, &p.MediaPath,
-			&p.IpHash, &p.Number, &p.ThumbPath, &p.Banned)
+		p, err := scanPost(rows)
 		if err != nil {
 			return nil, err
 		}
 		result = append(result, p)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	return result, rows.Err()
+	// load every file of the thread at once rather than per post
+	fileRows, err := db.Query(`
+		SELECT `+postFileColumns+`
+		FROM post_files
+		WHERE post_id IN (SELECT id FROM posts WHERE thread_id = ?)
+		ORDER BY post_id, position`, threadId)
+	if err != nil {
+		return nil, err
+	}
+	defer fileRows.Close()
+
+	filesByPost := make(map[int][]PostFile)
+	for fileRows.Next() {
+		f, err := scanPostFile(fileRows)
+		if err != nil {
+			return nil, err
+		}
+		filesByPost[f.PostId] = append(filesByPost[f.PostId], f)
+	}
+	for i := range result {
+		result[i].Files = filesByPost[result[i].Id]
+	}
+
+	return result, fileRows.Err()
 }
 
 func GetOriginalPost(db *sql.DB, threadId int) (Post, error) {
 	row := db.QueryRow(`
-		SELECT id, thread_id, author, body, created_at, media_path, 
-			   ip_hash, number, thumb_path, banned
+		SELECT `+postColumns+`
 		FROM posts 
 		WHERE thread_id = ? 
 		ORDER BY created_at ASC LIMIT 1`, threadId)
 
-	var r Post
-	err := row.Scan(
-		&r.Id, &r.ThreadId, &r.Author, &r.Body, &r.CreatedAt, &r.MediaPath,
-		&r.IpHash, &r.Number, &r.ThumbPath, &r.Banned)
+	r, err := scanPost(row)
+	if err != nil {
+		return Post{}, err
+	}
+
+	r.Files, err = GetPostFiles(db, r.Id)
 	if err != nil {
 		return Post{}, err
 	}
-	return r, row.Err()
+	return r, nil
 }
 
 func GetPost(db *sql.DB, postId int) (Post, error) {
 	row := db.QueryRow(`
-		SELECT id, thread_id, author, body, created_at, media_path, 
-			   ip_hash, number, thumb_path, banned
+		SELECT `+postColumns+`
 		FROM posts 
 		WHERE id = ?`, postId)
 
-	var r Post
-	err := row.Scan(
-		&r.Id, &r.ThreadId, &r.Author, &r.Body, &r.CreatedAt, &r.MediaPath,
-		&r.IpHash, &r.Number, &r.ThumbPath, &r.Banned)
+	r, err := scanPost(row)
 	if err != nil {
 		return Post{}, err
 	}
-	return r, row.Err()
-}
-
-func PutPost(db Queryer, boardSlug string, threadId int, body string, mediaPath string, thumbPath string, ip_hash string) error {
-	row := db.QueryRow(`
-		SELECT MAX(p.number)
-		FROM posts p 
-		INNER JOIN threads t ON p.thread_id = t.id
-		WHERE t.board_slug = ?`, boardSlug)
 
-	var latestPostNumber sql.NullInt64
-	if err := row.Scan(&latestPostNumber); err != nil {
-		return err
+	r.Files, err = GetPostFiles(db, r.Id)
+	if err != nil {
+		return Post{}, err
 	}
+	return r, nil
+}
 
-	newPostNumber := 1
-	if latestPostNumber.Valid {
-		newPostNumber = int(latestPostNumber.Int64) + 1
-	}
+// returned by PutPost when a post's files would pass its thread's image limit
+var ErrImageLimitReached = errors.New("image limit reached")
 
-	_, err := db.Exec(`
-		INSERT INTO posts (thread_id, body, media_path, ip_hash, number, thumb_path) 
-		VALUES (?, ?, ?, ?, ?, ?)`, threadId, body, mediaPath, ip_hash, newPostNumber, thumbPath)
+// inserts a reply and its files in a transaction, so replies posted at the
+// same time can't pass the thread's image limit together. returns the post id
+func PutReply(db *sql.DB, boardSlug string, post Post) (int, error) {
+	tx, err := db.Begin()
 	if err != nil {
-		return err
+		return -1, err
 	}
-
-	_, err = db.Exec(`UPDATE threads SET bumped_at = CURRENT_TIMESTAMP where id = ?`, threadId)
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	postId, err := PutPost(tx, boardSlug, post)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	return nil
+	return postId, tx.Commit()
 }
 
-func DeletePost(db *sql.DB, postId int) error {
-	// cleanup images
+// inserts post into post.ThreadId and returns its id. the number and created_at are assigned here.
+// returns ErrImageLimitReached when the files would take the thread past the
+// board's image limit, so run it in a transaction for the limit to hold
+func PutPost(db Queryer, boardSlug string, post Post) (int, error) {
+	if len(post.Files) > 0 {
+		var imageLimit int
+		if err := db.QueryRow(`SELECT image_limit FROM boards WHERE slug = ?`, boardSlug).
+			Scan(&imageLimit); err != nil {
+			return -1, err
+		}
+
+		if imageLimit > 0 {
+			stats, err := GetThreadStats(db, post.ThreadId)
+			if err != nil {
+				return -1, err
+			}
+			if stats.ImageCount+len(post.Files) > imageLimit {
+				return -1, ErrImageLimitReached
+			}
+		}
+	}
+
 	row := db.QueryRow(`
-		SELECT media_path, thumb_path
-		FROM posts
-		WHERE id = ?`, postId)
+		SELECT MAX(p.number)
+		FROM posts p 
+		INNER JOIN threads t ON p.thread_id = t.id
+		WHERE t.board_slug = ?`, boardSlug)
 
-	var (
-		pruneMediaThumbPath string
-		pruneMediaFullPath  string
-	)
-	if err := row.Scan(&pruneMediaFullPath, &pruneMediaThumbPath); err != nil {
-		return err
+	var latestPostNumber sql.NullInt64
+	if err := row.Scan(&latestPostNumber); err != nil {
+		return -1, err
 	}
 
-	if pruneMediaFullPath != "" {
-		if err := os.Remove(path.Join(util.POST_MEDIA_FULL_PATH, pruneMediaFullPath)); err != nil {
-			if !errors.Is(err, fs.ErrNotExist) {
-				return err
-			}
+	newPostNumber := 1
+	if latestPostNumber.Valid {
+		newPostNumber = int(latestPostNumber.Int64) + 1
+	}
+
+	if post.Author == "" {
+		post.Author = util.DEFAULT_AUTHOR
+	}
+
+	posterId, err := getPosterId(db, post.ThreadId, post.IpHash)
+	if err != nil {
+		return -1, err
+	}
+
+	res, err := db.Exec(`
+		INSERT INTO posts (thread_id, author, tripcode, body, ip_hash, number, poster_id, sage, spoiler, ip_range_hash) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		post.ThreadId, post.Author, post.Tripcode, post.Body, post.IpHash,
+		newPostNumber, posterId, post.Sage, post.Spoiler, post.IpRangeHash)
+	if err != nil {
+		return -1, err
+	}
+	postId, err := res.LastInsertId()
+	if err != nil {
+		return -1, err
+	}
+
+	for i, f := range post.Files {
+		_, err := db.Exec(`
+			INSERT INTO post_files (
+				post_id, position, media_path, thumb_path, sha256, phash, original_name,
+				metadata_stripped, mime_type, size, width, height, duration_ms, media_status,
+				preview_path)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			postId, i, f.MediaPath, f.ThumbPath, f.Sha256, f.Phash, f.OriginalName,
+			f.MetadataStripped, f.Info.MimeType, f.Info.Size, f.Info.Width, f.Info.Height,
+			f.Info.Duration.Milliseconds(), cmp.Or(f.MediaStatus, MediaStatusReady),
+			f.PreviewPath)
+		if err != nil {
+			return -1, err
 		}
 	}
 
-	if pruneMediaThumbPath != "" {
-		if err := os.Remove(path.Join(util.POST_MEDIA_THUMB_PATH, pruneMediaThumbPath)); err != nil {
-			if !errors.Is(err, fs.ErrNotExist) {
-				return err
-			}
+	if post.Sage {
+		return int(postId), nil
+	}
+
+	// replies past the board's bump limit no longer bump
+	var bumpLimit int
+	if err := db.QueryRow(`SELECT bump_limit FROM boards WHERE slug = ?`, boardSlug).
+		Scan(&bumpLimit); err != nil {
+		return -1, err
+	}
+
+	stats, err := GetThreadStats(db, post.ThreadId)
+	if err != nil {
+		return -1, err
+	}
+
+	if bumpLimit <= 0 || stats.ReplyCount <= bumpLimit {
+		_, err = db.Exec(`UPDATE threads SET bumped_at = CURRENT_TIMESTAMP where id = ?`, post.ThreadId)
+		if err != nil {
+			return -1, err
 		}
 	}
 
+	return int(postId), nil
+}
+
+func GetThreadStats(db Queryer, threadId int) (ThreadStats, error) {
+	row := db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) - 1 FROM posts WHERE thread_id = ?),
+			(SELECT COUNT(*) FROM post_files
+			 WHERE post_id IN (SELECT id FROM posts WHERE thread_id = ?))`, threadId, threadId)
+
+	var result ThreadStats
+	if err := row.Scan(&result.ReplyCount, &result.ImageCount); err != nil {
+		return ThreadStats{}, err
+	}
+
+	return result, nil
+}
+
+// reuses the poster's existing id in the thread so it survives salt rotation
+func getPosterId(db Queryer, threadId int, ipHash string) (string, error) {
+	row := db.QueryRow(`
+		SELECT poster_id
+		FROM posts
+		WHERE thread_id = ? AND ip_hash = ? AND poster_id != ''
+		LIMIT 1`, threadId, ipHash)
+
+	var existing string
+	err := row.Scan(&existing)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	salt, err := GetRotatingSecret(db, "poster_id", util.POSTER_ID_SALT_TTL)
+	if err != nil {
+		return "", err
+	}
+
+	return util.PosterId(salt, ipHash, threadId), nil
+}
+
+func DeletePost(db *sql.DB, postId int) error {
+	files, err := GetPostFiles(db, postId)
+	if err != nil {
+		return err
+	}
+
 	// delete post
-	_, err := db.Exec(`
+	_, err = db.Exec(`
 		DELETE FROM posts 
 		WHERE id = ?`, postId)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// cleanup images
+	return ReleasePostFiles(db, files)
+}
+
+func GetPostFile(db Queryer, fileId int) (PostFile, error) {
+	row := db.QueryRow(`
+		SELECT `+postFileColumns+`
+		FROM post_files
+		WHERE id = ?`, fileId)
+	return scanPostFile(row)
+}
+
+// returns a stored file with the given content hash so an upload can reuse it
+// instead of being saved again. with requireStripped only files saved without
+// their metadata match. files that failed processing are never reused
+func GetPostFileByHash(db Queryer, sha256 string, requireStripped bool) (PostFile, error) {
+	row := db.QueryRow(`
+		SELECT `+postFileColumns+`
+		FROM post_files
+		WHERE sha256 = ? AND media_path != '' AND media_status != 'failed'
+			AND (? = 0 OR metadata_stripped = 1)
+		ORDER BY id DESC LIMIT 1`, sha256, requireStripped)
+	return scanPostFile(row)
+}
+
+// returns one file per stored media path whose details were never recorded
+func GetPostFilesMissingInfo(db Queryer) ([]PostFile, error) {
+	rows, err := db.Query(`
+		SELECT ` + postFileColumns + `
+		FROM post_files
+		WHERE mime_type = '' AND media_path != ''
+		GROUP BY media_path
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []PostFile
+	for rows.Next() {
+		f, err := scanPostFile(rows)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// records the details of the media at mediaPath on every file sharing it
+func SetPostFileInfo(db Queryer, mediaPath string, info util.PostFileInfo) error {
+	_, err := db.Exec(`
+		UPDATE post_files
+		SET mime_type = ?, size = ?, width = ?, height = ?, duration_ms = ?
+		WHERE media_path = ?`,
+		info.MimeType, info.Size, info.Width, info.Height, info.Duration.Milliseconds(),
+		mediaPath)
+	return err
+}
+
+// queues processing of the pending upload stored as mediaPath. nothing is
+// queued when an unfinished job already covers it, since that job updates
+// every file sharing the media when it completes
+func EnqueueMediaJob(db Queryer, mediaPath string, stripMetadata bool) error {
+	_, err := db.Exec(`
+		INSERT INTO media_jobs (media_path, strip_metadata)
+		SELECT ?, ?
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM media_jobs
+			WHERE media_path = ? AND status IN ('pending', 'running')
+				AND strip_metadata >= ?)`,
+		mediaPath, stripMetadata, mediaPath, stripMetadata)
+	return err
+}
+
+// marks the oldest due job as running and returns it. sql.ErrNoRows when
+// there is none. jobs for the same media never run at once, so they finish
+// in the order they were queued. attempts only counts failed runs, so a job
+// interrupted by a restart keeps its retries
+func ClaimMediaJob(db Queryer) (MediaJob, error) {
+	row := db.QueryRow(`
+		UPDATE media_jobs
+		SET status = 'running', updated_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id
+			FROM media_jobs
+			WHERE status = 'pending' AND run_at <= CURRENT_TIMESTAMP
+				AND media_path NOT IN (
+					SELECT media_path FROM media_jobs WHERE status = 'running')
+			ORDER BY id
+			LIMIT 1)
+		RETURNING id, media_path, strip_metadata, status, attempts, last_error, created_at`)
+
+	var j MediaJob
+	err := row.Scan(&j.Id, &j.MediaPath, &j.StripMetadata, &j.Status, &j.Attempts,
+		&j.LastError, &j.CreatedAt)
+	return j, err
+}
+
+// records the processed media on the files sharing it and finishes the job. a
+// job that kept the metadata leaves files that asked for it to be stripped
+// pending for the job queued after it
+func CompleteMediaJob(db *sql.DB, job MediaJob, thumbPath, previewPath string, info util.PostFileInfo) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`
+		UPDATE post_files
+		SET thumb_path = ?, preview_path = ?, mime_type = ?, size = ?, width = ?,
+			height = ?, duration_ms = ?, metadata_stripped = ?, media_status = 'ready'
+		WHERE media_path = ? AND (? = 1 OR metadata_stripped = 0)`,
+		thumbPath, previewPath, info.MimeType, info.Size, info.Width, info.Height,
+		info.Duration.Milliseconds(), job.StripMetadata, job.MediaPath, job.StripMetadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE media_jobs
+		SET status = 'done', last_error = '', updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, job.Id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// puts a failed job back in the queue to run again after delay
+func RetryMediaJob(db Queryer, jobId int, jobErr error, delay time.Duration) error {
+	_, err := db.Exec(`
+		UPDATE media_jobs
+		SET status = 'pending', attempts = attempts + 1, last_error = ?,
+			run_at = datetime('now', ?), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`,
+		jobErr.Error(), fmt.Sprintf("+%d seconds", int64(delay.Seconds())), jobId)
+	return err
+}
+
+// gives up on a job. its files are marked failed unless another job
+// processed the same media
+func FailMediaJob(db *sql.DB, job MediaJob, jobErr error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`
+		UPDATE post_files
+		SET media_status = 'failed'
+		WHERE media_path = ? AND media_status = 'pending'`, job.MediaPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE media_jobs
+		SET status = 'failed', attempts = attempts + 1, last_error = ?,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, jobErr.Error(), job.Id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// finishes a job whose upload was deleted before it ran
+func SkipMediaJob(db Queryer, jobId int) error {
+	_, err := db.Exec(`
+		UPDATE media_jobs
+		SET status = 'done', updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, jobId)
+	return err
+}
+
+// deletes done and failed jobs that finished longer than retention ago.
+// returns how many there were
+func DeleteFinishedMediaJobs(db Queryer, retention time.Duration) (int, error) {
+	res, err := db.Exec(`
+		DELETE FROM media_jobs
+		WHERE status IN ('done', 'failed')
+			AND updated_at <= datetime('now', ?)`,
+		fmt.Sprintf("-%d seconds", int64(retention.Seconds())))
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// returns the threads with a post attached to the media at mediaPath
+func GetThreadIdsWithMedia(db Queryer, mediaPath string) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT p.thread_id
+		FROM post_files f
+		INNER JOIN posts p ON f.post_id = p.id
+		WHERE f.media_path = ?`, mediaPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// requeues jobs left running by a previous process. returns how many there were
+func ResetRunningMediaJobs(db Queryer) (int, error) {
+	res, err := db.Exec(`
+		UPDATE media_jobs
+		SET status = 'pending', updated_at = CURRENT_TIMESTAMP
+		WHERE status = 'running'`)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// reports whether a file with the given content hash was posted to the board
+// within window
+func HasRecentDuplicate(db Queryer, boardSlug string, sha256 string, window time.Duration) (bool, error) {
+	row := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM post_files f
+			INNER JOIN posts p ON f.post_id = p.id
+			INNER JOIN threads t ON p.thread_id = t.id
+			WHERE f.sha256 = ? AND t.board_slug = ?
+				AND p.created_at >= datetime('now', ?))`,
+		sha256, boardSlug, fmt.Sprintf("-%d seconds", int64(window.Seconds())))
+
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
 }
 
 func BanIp(db *sql.DB, ipHash string, reason string, expiration time.Time) error {
-	log.Printf("IP: %s, reason: %s, expiration: %v", ipHash, reason, expiration)
 	// if the ban already exists for ip, only update if its greater than existing
 	_, err := db.Exec(`
 		INSERT INTO bans (ip_hash, reason, expiration)
@@ -390,16 +1177,715 @@ func GetBan(db *sql.DB, ip string) (Ban, error) {
 	return r, nil
 }
 
-func GetAdmin(db *sql.DB, username string) (Admin, error) {
+// bans every ip in the range of rangeHash, see util.HashIpRange
+func BanIpRange(db *sql.DB, rangeHash string, reason string, expiration time.Time) error {
+	// like BanIp, an existing ban is only extended
+	_, err := db.Exec(`
+		INSERT INTO range_bans (range_hash, reason, expiration)
+		VALUES (?, ?, ?)
+		ON CONFLICT(range_hash) DO UPDATE SET
+			reason = excluded.reason,
+			expiration = excluded.expiration
+		WHERE excluded.expiration > range_bans.expiration
+	`, rangeHash, reason, expiration)
+	return err
+}
+
+// the ban on the range of rangeHash. returns ErrBanNotFound like GetBan
+func GetRangeBan(db *sql.DB, rangeHash string) (Ban, error) {
+	if rangeHash == "" {
+		return Ban{}, ErrBanNotFound
+	}
+
+	row := db.QueryRow(`
+		SELECT range_hash, reason, expiration
+		FROM range_bans
+		WHERE range_hash = ?`, rangeHash)
+
+	var r Ban
+	err := row.Scan(&r.IpHash, &r.Reason, &r.Expiration)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Ban{}, ErrBanNotFound
+		}
+		return Ban{}, err
+	}
+
+	if time.Now().After(r.Expiration) {
+		_, err := db.Exec(`
+			DELETE FROM range_bans
+			WHERE range_hash = ?`, rangeHash)
+		if err != nil {
+			return Ban{}, err
+		}
+		return Ban{}, ErrBanNotFound
+	}
+
+	return r, nil
+}
+
+const adminColumns = `id, username, password, role`
+
+var ErrAdminNotFound = errors.New("admin not found")
+
+func scanAdmin(db Queryer, row rowScanner) (Admin, error) {
+	var a Admin
+	if err := row.Scan(&a.Id, &a.Username, &a.Password, &a.Role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Admin{}, ErrAdminNotFound
+		}
+		return Admin{}, err
+	}
+
+	boards, err := getAdminBoards(db, a.Id)
+	if err != nil {
+		return Admin{}, err
+	}
+	a.Boards = boards
+
+	return a, nil
+}
+
+func getAdminBoards(db Queryer, adminId int) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT board_slug
+		FROM admin_boards
+		WHERE admin_id = ?
+		ORDER BY board_slug`, adminId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		slugs = append(slugs, slug)
+	}
+	return slugs, rows.Err()
+}
+
+func GetAdmin(db Queryer, username string) (Admin, error) {
 	row := db.QueryRow(`
-		SELECT username, password
+		SELECT `+adminColumns+`
 		FROM admins
 		WHERE username = ?`, username)
+	return scanAdmin(db, row)
+}
+
+func GetAdminById(db Queryer, id int) (Admin, error) {
+	row := db.QueryRow(`
+		SELECT `+adminColumns+`
+		FROM admins
+		WHERE id = ?`, id)
+	return scanAdmin(db, row)
+}
 
-	var result Admin
-	if err := row.Scan(&result.Username, &result.Password); err != nil {
-		return Admin{}, nil
+func GetAdmins(db *sql.DB) ([]Admin, error) {
+	rows, err := db.Query(`
+		SELECT ` + adminColumns + `
+		FROM admins
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	var result []Admin
+	for rows.Next() {
+		var a Admin
+		if err := rows.Scan(&a.Id, &a.Username, &a.Password, &a.Role); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result = append(result, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		result[i].Boards, err = getAdminBoards(db, result[i].Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func CountAdminsWithRole(db Queryer, role util.StaffRole) (int, error) {
+	row := db.QueryRow(`SELECT COUNT(*) FROM admins WHERE role = ?`, role)
+
+	var count int
+	err := row.Scan(&count)
+	return count, err
+}
+
+func setAdminBoards(tx *sql.Tx, adminId int, boards []string) error {
+	_, err := tx.Exec(`DELETE FROM admin_boards WHERE admin_id = ?`, adminId)
+	if err != nil {
+		return err
+	}
+
+	for _, slug := range boards {
+		_, err := tx.Exec(`
+			INSERT INTO admin_boards (admin_id, board_slug)
+			VALUES (?, ?)`, adminId, slug)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changes the role of an admin and the boards it applies to
+func UpdateAdminRole(db *sql.DB, adminId int, role util.StaffRole, boards []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`UPDATE admins SET role = ? WHERE id = ?`, role, adminId)
+	if err != nil {
+		return err
+	}
+
+	if err := setAdminBoards(tx, adminId, boards); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// removes an admin from the staff and logs them out everywhere
+func DeleteAdmin(db *sql.DB, admin Admin) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`DELETE FROM admin_sessions WHERE username = ?`, admin.Username)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM admins WHERE id = ?`, admin.Id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const staffInviteColumns = `id, role, boards, created_by, created_at, expires_at`
+
+func scanStaffInvite(row rowScanner) (StaffInvite, error) {
+	var i StaffInvite
+	var boards string
+	err := row.Scan(&i.Id, &i.Role, &boards, &i.CreatedBy, &i.CreatedAt, &i.ExpiresAt)
+	if boards != "" {
+		i.Boards = strings.Split(boards, ",")
+	}
+	return i, err
+}
+
+// stores an invite for the hashed token that can be accepted within ttl
+func CreateStaffInvite(db Queryer, tokenHash string, invite StaffInvite, ttl time.Duration) error {
+	_, err := db.Exec(`
+		INSERT INTO staff_invites (token_hash, role, boards, created_by, expires_at)
+		VALUES (?, ?, ?, ?, datetime('now', ?))`,
+		tokenHash, invite.Role, strings.Join(invite.Boards, ","), invite.CreatedBy,
+		fmt.Sprintf("+%d seconds", int64(ttl.Seconds())))
+	return err
+}
+
+// returns the invites that have not been accepted or expired, newest first
+func GetStaffInvites(db Queryer) ([]StaffInvite, error) {
+	rows, err := db.Query(`
+		SELECT ` + staffInviteColumns + `
+		FROM staff_invites
+		WHERE expires_at > CURRENT_TIMESTAMP
+		ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []StaffInvite
+	for rows.Next() {
+		i, err := scanStaffInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, i)
+	}
+	return result, rows.Err()
+}
+
+var ErrStaffInviteNotFound = errors.New("staff invite not found")
+
+// returns the unexpired invite of the hashed token
+func GetStaffInvite(db Queryer, tokenHash string) (StaffInvite, error) {
+	row := db.QueryRow(`
+		SELECT `+staffInviteColumns+`
+		FROM staff_invites
+		WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP`, tokenHash)
+
+	result, err := scanStaffInvite(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StaffInvite{}, ErrStaffInviteNotFound
+	}
+	return result, err
+}
+
+func DeleteStaffInvite(db Queryer, id int) error {
+	_, err := db.Exec(`DELETE FROM staff_invites WHERE id = ?`, id)
+	return err
+}
+
+// creates the account an invite was for and uses up the invite. boards that
+// were deleted since the invite was made are skipped
+func AcceptStaffInvite(db *sql.DB, invite StaffInvite, username, passwordHash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	res, err := tx.Exec(`DELETE FROM staff_invites WHERE id = ?`, invite.Id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrStaffInviteNotFound
+	}
+
+	row := tx.QueryRow(`
+		INSERT INTO admins (username, password, role)
+		VALUES (?, ?, ?)
+		RETURNING id`, username, passwordHash, invite.Role)
+
+	var adminId int
+	if err := row.Scan(&adminId); err != nil {
+		return err
+	}
+
+	for _, slug := range invite.Boards {
+		_, err := tx.Exec(`
+			INSERT INTO admin_boards (admin_id, board_slug)
+			SELECT ?, slug FROM boards WHERE slug = ?`, adminId, slug)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+const adminSessionColumns = `
+	id, username, ip_hash, user_agent, created_at, last_seen_at, expires_at`
+
+func scanAdminSession(row rowScanner) (AdminSession, error) {
+	var s AdminSession
+	err := row.Scan(&s.Id, &s.Username, &s.IpHash, &s.UserAgent, &s.CreatedAt,
+		&s.LastSeenAt, &s.ExpiresAt)
+	return s, err
+}
+
+// stores a session for the hashed token that expires after going unused for
+// ttl. expired sessions of every admin are removed at the same time
+func CreateAdminSession(db *sql.DB, tokenHash string, session AdminSession, ttl time.Duration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	_, err = tx.Exec(`
+		DELETE FROM admin_sessions
+		WHERE expires_at <= CURRENT_TIMESTAMP`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO admin_sessions (token_hash, username, ip_hash, user_agent, expires_at)
+		VALUES (?, ?, ?, ?, datetime('now', ?))`,
+		tokenHash, session.Username, session.IpHash, session.UserAgent,
+		fmt.Sprintf("+%d seconds", int64(ttl.Seconds())))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+var ErrAdminSessionNotFound = errors.New("admin session not found")
+
+// returns the unexpired session of the hashed token
+func GetAdminSession(db Queryer, tokenHash string) (AdminSession, error) {
+	row := db.QueryRow(`
+		SELECT `+adminSessionColumns+`
+		FROM admin_sessions
+		WHERE token_hash = ? AND expires_at > CURRENT_TIMESTAMP`, tokenHash)
+
+	result, err := scanAdminSession(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AdminSession{}, ErrAdminSessionNotFound
+	}
+	return result, err
+}
+
+// pushes the expiry of a session in use to ttl from now. sessions seen within
+// the last minute are left alone to spare a write on every request. reports
+// whether the session was updated
+func TouchAdminSession(db Queryer, sessionId int, ttl time.Duration) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE admin_sessions
+		SET last_seen_at = CURRENT_TIMESTAMP, expires_at = datetime('now', ?)
+		WHERE id = ? AND last_seen_at <= datetime('now', '-60 seconds')`,
+		fmt.Sprintf("+%d seconds", int64(ttl.Seconds())), sessionId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// returns the unexpired sessions of username, most recently used first
+func GetAdminSessions(db Queryer, username string) ([]AdminSession, error) {
+	rows, err := db.Query(`
+		SELECT `+adminSessionColumns+`
+		FROM admin_sessions
+		WHERE username = ? AND expires_at > CURRENT_TIMESTAMP
+		ORDER BY last_seen_at DESC, id DESC`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []AdminSession
+	for rows.Next() {
+		s, err := scanAdminSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+func DeleteAdminSession(db Queryer, tokenHash string) error {
+	_, err := db.Exec(`DELETE FROM admin_sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+// ends one of username's sessions. sessions of other admins are left alone
+func RevokeAdminSession(db Queryer, username string, sessionId int) error {
+	_, err := db.Exec(`
+		DELETE FROM admin_sessions
+		WHERE id = ? AND username = ?`, sessionId, username)
+	return err
+}
+
+// ends every session of username except keepId
+func RevokeOtherAdminSessions(db Queryer, username string, keepId int) error {
+	_, err := db.Exec(`
+		DELETE FROM admin_sessions
+		WHERE username = ? AND id != ?`, username, keepId)
+	return err
+}
+
+// returns the named secret, generating and storing a random one on first use
+func GetSecret(db Queryer, name string) (string, error) {
+	value, err := util.GenToken()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO secrets (name, value)
+		VALUES (?, ?)`, name, value)
+	if err != nil {
+		return "", err
+	}
+
+	row := db.QueryRow(`
+		SELECT value
+		FROM secrets
+		WHERE name = ?`, name)
+
+	var result string
+	if err := row.Scan(&result); err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+// like GetSecret, but the secret is regenerated once it is older than ttl
+func GetRotatingSecret(db Queryer, name string, ttl time.Duration) (string, error) {
+	_, err := db.Exec(`
+		DELETE FROM secrets
+		WHERE name = ? AND created_at < datetime('now', ?)`,
+		name, fmt.Sprintf("-%d seconds", int64(ttl.Seconds())))
+	if err != nil {
+		return "", err
+	}
+
+	return GetSecret(db, name)
+}
+
+const bannedHashColumns = `id, sha256, phash, reason, created_at`
+
+func scanBannedHash(row rowScanner) (BannedHash, error) {
+	var b BannedHash
+	err := row.Scan(&b.Id, &b.Sha256, &b.Phash, &b.Reason, &b.CreatedAt)
+	return b, err
+}
+
+func GetBannedHashes(db *sql.DB) ([]BannedHash, error) {
+	rows, err := db.Query(`
+		SELECT ` + bannedHashColumns + `
+		FROM banned_hashes
+		ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []BannedHash
+	for rows.Next() {
+		b, err := scanBannedHash(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, b)
+	}
+
+	return result, rows.Err()
+}
+
+var ErrBannedHashNotFound = errors.New("banned hash not found")
+
+// returns the ban matching the upload exactly, or failing that one whose
+// perceptual hash is within util.PHASH_BAN_DISTANCE
+func FindBannedHash(db *sql.DB, hash util.PostFileHash) (BannedHash, error) {
+	row := db.QueryRow(`
+		SELECT `+bannedHashColumns+`
+		FROM banned_hashes
+		WHERE sha256 = ?`, hash.Sha256)
+
+	result, err := scanBannedHash(row)
+	if err == nil {
+		return result, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return BannedHash{}, err
+	}
+
+	if hash.Phash == "" {
+		return BannedHash{}, ErrBannedHashNotFound
+	}
+
+	bans, err := GetBannedHashes(db)
+	if err != nil {
+		return BannedHash{}, err
+	}
+	for _, ban := range bans {
+		distance := util.PerceptualHashDistance(ban.Phash, hash.Phash)
+		if distance >= 0 && distance <= util.PHASH_BAN_DISTANCE {
+			return ban, nil
+		}
+	}
+
+	return BannedHash{}, ErrBannedHashNotFound
+}
+
+func BanHash(db *sql.DB, sha256, phash, reason string) error {
+	_, err := db.Exec(`
+		INSERT INTO banned_hashes (sha256, phash, reason)
+		VALUES (?, ?, ?)
+		ON CONFLICT(sha256) DO UPDATE SET reason = excluded.reason`,
+		sha256, phash, reason)
+	return err
+}
+
+func UnbanHash(db *sql.DB, id int) error {
+	_, err := db.Exec(`DELETE FROM banned_hashes WHERE id = ?`, id)
+	return err
+}
+
+func GetBannedHash(db *sql.DB, id int) (BannedHash, error) {
+	row := db.QueryRow(`
+		SELECT `+bannedHashColumns+`
+		FROM banned_hashes
+		WHERE id = ?`, id)
+	return scanBannedHash(row)
+}
+
+func AddModLogEntry(db Queryer, entry ModLogEntry) error {
+	_, err := db.Exec(`
+		INSERT INTO mod_log (actor, action, board_slug, thread_id, post_number, reason, snapshot)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.Actor, entry.Action, entry.BoardSlug, entry.ThreadId, entry.PostNumber,
+		entry.Reason, entry.Snapshot)
+	return err
+}
+
+// narrows down GetModLog. empty fields match everything
+type ModLogFilter struct {
+	Actor     string
+	Action    string
+	BoardSlug string
+	// entries older than this id, for paging. 0 starts from the newest
+	BeforeId int
+	Limit    int
+}
+
+// returns the entries matching the filter, newest first
+func GetModLog(db *sql.DB, filter ModLogFilter) ([]ModLogEntry, error) {
+	query := `
+		SELECT id, actor, action, board_slug, thread_id, post_number, reason, snapshot, created_at
+		FROM mod_log
+		WHERE 1 = 1`
+	var args []any
+	if filter.Actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		query += ` AND action = ?`
+		args = append(args, filter.Action)
+	}
+	if filter.BoardSlug != "" {
+		query += ` AND board_slug = ?`
+		args = append(args, filter.BoardSlug)
+	}
+	if filter.BeforeId > 0 {
+		query += ` AND id < ?`
+		args = append(args, filter.BeforeId)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []ModLogEntry
+	for rows.Next() {
+		var e ModLogEntry
+		err := rows.Scan(&e.Id, &e.Actor, &e.Action, &e.BoardSlug, &e.ThreadId,
+			&e.PostNumber, &e.Reason, &e.Snapshot, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+
+	return result, rows.Err()
+}
+
+// adds the report unless the ip already reported the post
+func PutReport(db *sql.DB, report Report) error {
+	_, err := db.Exec(`
+		INSERT INTO reports (post_id, category, comment, ip_hash)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (post_id, ip_hash) DO NOTHING`,
+		report.PostId, report.Category, report.Comment, report.IpHash)
+	return err
+}
+
+// returns the reports of postId, oldest first
+func GetReports(db *sql.DB, postId int) ([]Report, error) {
+	rows, err := db.Query(`
+		SELECT id, post_id, category, comment, ip_hash, created_at
+		FROM reports
+		WHERE post_id = ?
+		ORDER BY id ASC`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Report
+	for rows.Next() {
+		var r Report
+		err := rows.Scan(&r.Id, &r.PostId, &r.Category, &r.Comment, &r.IpHash, &r.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// returns every reported post with its reports, the most reported first
+func GetReportedPosts(db *sql.DB) ([]ReportedPost, error) {
+	rows, err := db.Query(`
+		SELECT r.post_id, t.board_slug,
+			p.id = (SELECT MIN(id) FROM posts WHERE thread_id = p.thread_id)
+		FROM reports r
+		INNER JOIN posts p ON p.id = r.post_id
+		INNER JOIN threads t ON t.id = p.thread_id
+		GROUP BY r.post_id
+		ORDER BY COUNT(*) DESC, MAX(r.id) DESC`)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []ReportedPost
+	for rows.Next() {
+		var rp ReportedPost
+		if err := rows.Scan(&rp.Post.Id, &rp.BoardSlug, &rp.IsOp); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result = append(result, rp)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		rp := &result[i]
+		var err error
+		if rp.Post, err = GetPost(db, rp.Post.Id); err != nil {
+			return nil, err
+		}
+		if rp.Reports, err = GetReports(db, rp.Post.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// dismisses every report of postId
+func DeleteReports(db *sql.DB, postId int) error {
+	_, err := db.Exec(`
+		DELETE FROM reports
+		WHERE post_id = ?`, postId)
+	return err
 }
@@ -0,0 +1,476 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/util"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// a migrated database in a temporary directory, with the seeded boards
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// points util.MEDIA_STORE at a temporary directory for the test
+func useTestMediaStore(t *testing.T) util.MediaStore {
+	t.Helper()
+	previous := util.MEDIA_STORE
+	util.MEDIA_STORE = &util.LocalMediaStore{Root: t.TempDir(), URLPrefix: "/media"}
+	t.Cleanup(func() { util.MEDIA_STORE = previous })
+	return util.MEDIA_STORE
+}
+
+func TestFindBannedHash(t *testing.T) {
+	db := newTestDB(t)
+
+	const bannedSha = "aaaa"
+	const bannedPhash = "0f0f0f0f0f0f0f0f"
+	if err := BanHash(db, bannedSha, bannedPhash, "gross"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash util.PostFileHash
+		want bool
+	}{
+		{"exact file", util.PostFileHash{Sha256: bannedSha}, true},
+		{"exact file with another phash", util.PostFileHash{Sha256: bannedSha, Phash: "ffffffffffffffff"}, true},
+		{"same phash", util.PostFileHash{Sha256: "bbbb", Phash: bannedPhash}, true},
+		{"phash at the ban distance", util.PostFileHash{Sha256: "bbbb", Phash: "0f0f0f0f0f0f0cf0"}, true},    // 10 bits
+		{"phash past the ban distance", util.PostFileHash{Sha256: "bbbb", Phash: "0f0f0f0f0f0f08f0"}, false}, // 11 bits
+		{"unrelated phash", util.PostFileHash{Sha256: "bbbb", Phash: "f0f0f0f0f0f0f0f0"}, false},
+		{"video without phash", util.PostFileHash{Sha256: "bbbb"}, false},
+		{"malformed phash", util.PostFileHash{Sha256: "bbbb", Phash: "nope"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ban, err := FindBannedHash(db, tt.hash)
+			if !tt.want {
+				if !errors.Is(err, ErrBannedHashNotFound) {
+					t.Errorf("FindBannedHash() = %+v, %v, want ErrBannedHashNotFound", ban, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindBannedHash() error = %v", err)
+			}
+			if ban.Sha256 != bannedSha || ban.Reason != "gross" {
+				t.Errorf("FindBannedHash() = %+v, want the ban of %q", ban, bannedSha)
+			}
+		})
+	}
+}
+
+func putTestMedia(t *testing.T, store util.MediaStore, name string) {
+	t.Helper()
+	if err := store.Put(util.PostMediaKey(name), strings.NewReader("media"), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mediaExists(store util.MediaStore, name string) bool {
+	obj, err := store.Open(util.PostMediaKey(name))
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, obj)
+	obj.Close()
+	return true
+}
+
+// releases every released_media row as if it happened long ago
+func ageReleasedMedia(t *testing.T, db *sql.DB) {
+	t.Helper()
+	if _, err := db.Exec(`UPDATE released_media SET released_at = datetime('now', '-1 day')`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweepReleasedMedia(t *testing.T) {
+	db := newTestDB(t)
+	store := useTestMediaStore(t)
+
+	putFile := func(name string) PostFile {
+		putTestMedia(t, store, name)
+		return PostFile{MediaPath: name, Sha256: strings.TrimSuffix(name, ".png"), MediaStatus: MediaStatusReady}
+	}
+
+	shared := putFile("shared.png")
+	threadId, _, err := PutThread(db, "c", "first", Post{Body: "op", Files: []PostFile{shared}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, err := GetPosts(db, threadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the only post with the media is deleted while another upload was
+	// deduplicated against it, but before that upload's post is saved
+	reused, err := GetPostFileByHash(db, shared.Sha256, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeletePost(db, posts[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if !mediaExists(store, shared.MediaPath) {
+		t.Fatal("media was deleted along with its last post")
+	}
+
+	// the grace period has not passed yet
+	if n, err := SweepReleasedMedia(db, util.RELEASED_MEDIA_GRACE); err != nil || n != 0 {
+		t.Fatalf("SweepReleasedMedia() = %d, %v, want 0", n, err)
+	}
+
+	if _, _, err := PutThread(db, "c", "second", Post{Body: "reuse", Files: []PostFile{reused}}); err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := putFile("orphan.png")
+	if err := ReleasePostFiles(db, []PostFile{orphan}); err != nil {
+		t.Fatal(err)
+	}
+
+	ageReleasedMedia(t, db)
+	n, err := SweepReleasedMedia(db, util.RELEASED_MEDIA_GRACE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("SweepReleasedMedia() = %d, want 1", n)
+	}
+	if !mediaExists(store, shared.MediaPath) {
+		t.Error("media claimed by a new post was swept")
+	}
+	if mediaExists(store, orphan.MediaPath) {
+		t.Error("unreferenced media was not swept")
+	}
+}
+
+func TestReclaimMedia(t *testing.T) {
+	db := newTestDB(t)
+	store := useTestMediaStore(t)
+
+	putTestMedia(t, store, "upload.png")
+	file := PostFile{MediaPath: "upload.png"}
+	if err := ReleasePostFiles(db, []PostFile{file}); err != nil {
+		t.Fatal(err)
+	}
+	ageReleasedMedia(t, db)
+
+	// an upload of the same content is being saved under the same name
+	if err := ReclaimMedia(db, file.MediaPath); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := SweepReleasedMedia(db, util.RELEASED_MEDIA_GRACE); err != nil || n != 0 {
+		t.Fatalf("SweepReleasedMedia() = %d, %v, want 0", n, err)
+	}
+	if !mediaExists(store, file.MediaPath) {
+		t.Error("reclaimed media was swept")
+	}
+}
+
+func TestPutReplyImageLimit(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec(`UPDATE boards SET image_limit = 3 WHERE slug = 'c'`); err != nil {
+		t.Fatal(err)
+	}
+
+	file := func(name string) PostFile {
+		return PostFile{MediaPath: name, Sha256: name, MediaStatus: MediaStatusReady}
+	}
+	threadId, _, err := PutThread(db, "c", "limited", Post{Body: "op", Files: []PostFile{file("a.png")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		files   []PostFile
+		wantErr error
+	}{
+		{"under the limit", []PostFile{file("b.png")}, nil},
+		{"past the limit", []PostFile{file("c.png"), file("d.png")}, ErrImageLimitReached},
+		{"without files", nil, nil},
+		{"up to the limit", []PostFile{file("c.png")}, nil},
+		{"at the limit", []PostFile{file("d.png")}, ErrImageLimitReached},
+	}
+	for _, tt := range tests {
+		_, err := PutReply(db, "c", Post{ThreadId: threadId, Body: tt.name, Files: tt.files})
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: PutReply() error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	stats, err := GetThreadStats(db, threadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ReplyCount != 3 || stats.ImageCount != 3 {
+		t.Errorf("thread has %d replies and %d images, want 3 and 3", stats.ReplyCount, stats.ImageCount)
+	}
+}
+
+func TestDeleteArchivedThreads(t *testing.T) {
+	db := newTestDB(t)
+	store := useTestMediaStore(t)
+
+	putThread := func(subject, archivedAgo string) int {
+		putTestMedia(t, store, subject+".png")
+		file := PostFile{MediaPath: subject + ".png", Sha256: subject, MediaStatus: MediaStatusReady}
+		threadId, _, err := PutThread(db, "c", subject, Post{Body: "op", Files: []PostFile{file}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archivedAgo != "" {
+			_, err := db.Exec(`UPDATE threads SET archived = 1, archived_at = datetime('now', ?) WHERE id = ?`,
+				archivedAgo, threadId)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return threadId
+	}
+
+	live := putThread("live", "")
+	recent := putThread("recent", "-1 hour")
+	old := putThread("old", "-2 days")
+
+	if n, err := DeleteArchivedThreads(db, -1); err != nil || n != 0 {
+		t.Fatalf("DeleteArchivedThreads(-1) = %d, %v, want 0", n, err)
+	}
+
+	n, err := DeleteArchivedThreads(db, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteArchivedThreads() = %d, want 1", n)
+	}
+
+	for _, tt := range []struct {
+		threadId int
+		want     bool
+	}{{live, true}, {recent, true}, {old, false}} {
+		_, err := GetThread(db, tt.threadId)
+		if exists := err == nil; exists != tt.want {
+			t.Errorf("thread %d exists = %v (%v), want %v", tt.threadId, exists, err, tt.want)
+		}
+	}
+
+	// the media is left to SweepReleasedMedia
+	var released []string
+	rows, err := db.Query(`SELECT media_path FROM released_media`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			t.Fatal(err)
+		}
+		released = append(released, path)
+	}
+	if len(released) != 1 || released[0] != "old.png" {
+		t.Errorf("released media = %v, want [old.png]", released)
+	}
+}
+
+func TestPurgeArchivedMedia(t *testing.T) {
+	db := newTestDB(t)
+	store := useTestMediaStore(t)
+
+	putThread := func(subject, archivedAgo string) int {
+		putTestMedia(t, store, subject+".png")
+		file := PostFile{MediaPath: subject + ".png", Sha256: subject, MediaStatus: MediaStatusReady}
+		threadId, _, err := PutThread(db, "c", subject, Post{Body: "op", Files: []PostFile{file}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.Exec(`UPDATE threads SET archived = 1, archived_at = datetime('now', ?) WHERE id = ?`,
+			archivedAgo, threadId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return threadId
+	}
+	recent := putThread("recent", "-1 hour")
+	old := putThread("old", "-2 days")
+
+	n, err := PurgeArchivedMedia(db, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("PurgeArchivedMedia() = %d, want 1", n)
+	}
+
+	// purged files lose their rows rather than their paths, so nothing is
+	// left pointing at the released media
+	for _, tt := range []struct {
+		threadId int
+		want     int
+	}{{recent, 1}, {old, 0}} {
+		posts, err := GetPosts(db, tt.threadId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(posts) != 1 || len(posts[0].Files) != tt.want {
+			t.Errorf("thread %d has %d posts with %v files, want 1 post with %d", tt.threadId, len(posts), posts, tt.want)
+		}
+	}
+
+	var released int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM released_media WHERE media_path = 'old.png'`).Scan(&released); err != nil {
+		t.Fatal(err)
+	}
+	if released != 1 {
+		t.Error("old.png was not released")
+	}
+}
+
+func TestMediaJobAttempts(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := EnqueueMediaJob(db, "upload.mp4", false); err != nil {
+		t.Fatal(err)
+	}
+
+	claim := func(wantAttempts int) MediaJob {
+		t.Helper()
+		job, err := ClaimMediaJob(db)
+		if err != nil {
+			t.Fatalf("ClaimMediaJob() error = %v", err)
+		}
+		if job.Attempts != wantAttempts {
+			t.Fatalf("ClaimMediaJob() attempts = %d, want %d", job.Attempts, wantAttempts)
+		}
+		return job
+	}
+
+	claim(0)
+	// interrupted by a restart
+	if _, err := ResetRunningMediaJobs(db); err != nil {
+		t.Fatal(err)
+	}
+	job := claim(0)
+
+	if err := RetryMediaJob(db, job.Id, errors.New("ffmpeg crashed"), 0); err != nil {
+		t.Fatal(err)
+	}
+	job = claim(1)
+
+	if err := FailMediaJob(db, job, errors.New("ffmpeg crashed again")); err != nil {
+		t.Fatal(err)
+	}
+	var status string
+	var attempts int
+	if err := db.QueryRow(`SELECT status, attempts FROM media_jobs WHERE id = ?`, job.Id).
+		Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != MediaJobFailed || attempts != 2 {
+		t.Errorf("job is %s after %d attempts, want failed after 2", status, attempts)
+	}
+}
+
+func TestDeleteFinishedMediaJobs(t *testing.T) {
+	db := newTestDB(t)
+
+	for _, job := range []struct {
+		status, finishedAgo string
+	}{
+		{"done", "-2 days"},
+		{"failed", "-2 days"},
+		{"done", "-1 hour"},
+		{"pending", "-2 days"},
+		{"running", "-2 days"},
+	} {
+		_, err := db.Exec(`
+			INSERT INTO media_jobs (media_path, status, updated_at)
+			VALUES (?, ?, datetime('now', ?))`,
+			job.status+job.finishedAgo, job.status, job.finishedAgo)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := DeleteFinishedMediaJobs(db, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("DeleteFinishedMediaJobs() = %d, want 2", n)
+	}
+
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM media_jobs`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 3 {
+		t.Errorf("%d jobs left, want 3", left)
+	}
+}
+
+func TestDeleteBoard(t *testing.T) {
+	db := newTestDB(t)
+	store := useTestMediaStore(t)
+
+	file := func(name string) PostFile {
+		putTestMedia(t, store, name)
+		return PostFile{MediaPath: name, Sha256: name, MediaStatus: MediaStatusReady}
+	}
+	own, shared := file("own.png"), file("shared.png")
+
+	if _, _, err := PutThread(db, "c", "doomed", Post{Body: "op", Files: []PostFile{own, shared}}); err != nil {
+		t.Fatal(err)
+	}
+	// the same upload on another board
+	if _, _, err := PutThread(db, "r", "kept", Post{Body: "op", Files: []PostFile{shared}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteBoard(db, "c"); err != nil {
+		t.Fatalf("DeleteBoard() error = %v", err)
+	}
+	if _, err := GetBoard(db, "c"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetBoard() error = %v, want sql.ErrNoRows", err)
+	}
+
+	var released []string
+	rows, err := db.Query(`SELECT media_path FROM released_media ORDER BY media_path`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			t.Fatal(err)
+		}
+		released = append(released, path)
+	}
+	if len(released) != 1 || released[0] != own.MediaPath {
+		t.Errorf("released media = %v, want [%s]", released, own.MediaPath)
+	}
+}
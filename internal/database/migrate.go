@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrations are named <version>_<name>.up.sql and <version>_<name>.down.sql
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(fileName, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(fileName, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: missing .up.sql or .down.sql suffix", fileName)
+		}
+
+		versionStr, name, found := strings.Cut(strings.TrimSuffix(fileName, "."+direction+".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s: missing name", fileName)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version: %w", fileName, err)
+		}
+
+		contents, err := fs.ReadFile(migrationFiles, path.Join("migrations", fileName))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d: conflicting names %q and %q", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(contents)
+		} else {
+			m.Down = string(contents)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d: missing up script", m.Version)
+		}
+		result = append(result, *m)
+	}
+	slices.SortFunc(result, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+
+	return result, nil
+}
+
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	return err
+}
+
+func getAppliedMigrations(db *sql.DB) (map[int]time.Time, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+
+	return result, rows.Err()
+}
+
+func GetMigrationStatus(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := getAppliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		appliedAt, isApplied := applied[m.Version]
+		result = append(result, MigrationStatus{
+			Migration: m,
+			Applied:   isApplied,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return result, nil
+}
+
+// applies every pending migration in order. returns the applied migrations
+func MigrateUp(db *sql.DB) ([]Migration, error) {
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Migration
+	for _, status := range statuses {
+		if status.Applied {
+			continue
+		}
+
+		m := status.Migration
+		if err := runMigration(db, m.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name)
+			return err
+		}); err != nil {
+			return result, fmt.Errorf("migration %d_%s up: %w", m.Version, m.Name, err)
+		}
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// reverts the latest `steps` applied migrations. returns the reverted migrations
+func MigrateDown(db *sql.DB, steps int) ([]Migration, error) {
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Migration
+	for i := len(statuses) - 1; i >= 0 && len(result) < steps; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+
+		m := statuses[i].Migration
+		if m.Down == "" {
+			return result, fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+		}
+
+		if err := runMigration(db, m.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version)
+			return err
+		}); err != nil {
+			return result, fmt.Errorf("migration %d_%s down: %w", m.Version, m.Name, err)
+		}
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+func runMigration(db *sql.DB, script string, record func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.Exec(script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
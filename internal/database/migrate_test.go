@@ -0,0 +1,140 @@
+package database
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+)
+
+// reverts every migration after version
+func migrateDownTo(t *testing.T, db *sql.DB, version int) {
+	t.Helper()
+	statuses, err := GetMigrationStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := 0
+	for _, status := range statuses {
+		if status.Applied && status.Version > version {
+			steps++
+		}
+	}
+	if _, err := MigrateDown(db, steps); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniqueAdminUsernamesMigration(t *testing.T) {
+	db := newTestDB(t)
+	migrateDownTo(t, db, 23)
+
+	// a database that lost the index 0018 created. the seeded admin has id 1
+	if _, err := db.Exec(`DROP INDEX idx_admins_username`); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`
+		INSERT INTO admins (username, password) VALUES
+			('admin', 'second'),
+			('mod', 'first'),
+			('admin', 'third'),
+			('mod', 'second')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	rows, err := db.Query(`SELECT username, password FROM admins ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got [][2]string
+	for rows.Next() {
+		var username, password string
+		if err := rows.Scan(&username, &password); err != nil {
+			t.Fatal(err)
+		}
+		if password != "second" && password != "third" && password != "first" {
+			password = "seed"
+		}
+		got = append(got, [2]string{username, password})
+	}
+
+	want := [][2]string{
+		{"admin", "seed"},
+		{"admin-2", "second"},
+		{"mod", "first"},
+		{"admin-4", "third"},
+		{"mod-5", "second"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("admins = %v, want %v", got, want)
+	}
+
+	if _, err := db.Exec(`INSERT INTO admins (username, password) VALUES ('mod', 'again')`); err == nil {
+		t.Error("inserted a duplicate username after the migration")
+	}
+}
+
+func TestModLogIpHashesMigration(t *testing.T) {
+	db := newTestDB(t)
+	migrateDownTo(t, db, 22)
+
+	snapshots := []struct {
+		before, after string
+	}{
+		{
+			`{"number": 1, "body": "hi", "ip_hash": "a", "ip_range_hash": "b"}`,
+			`{"number":1,"body":"hi"}`,
+		},
+		{
+			`{"expiration": "2030-01-01T00:00:00Z", "post": {"number": 2, "ip_hash": "a"}}`,
+			`{"expiration":"2030-01-01T00:00:00Z","post":{"number":2}}`,
+		},
+		{
+			`{"subject": "s", "posts": [{"number": 3, "ip_hash": "a"}, {"number": 4, "ip_hash": "b", "ip_range_hash": "c"}]}`,
+			`{"subject":"s","posts":[{"number":3},{"number":4}]}`,
+		},
+		// report and file snapshots never had hashes
+		{`[{"category": "spam"}]`, `[{"category": "spam"}]`},
+		{`{"sha256": "abc"}`, `{"sha256": "abc"}`},
+		{``, ``},
+	}
+	for _, s := range snapshots {
+		if _, err := db.Exec(`INSERT INTO mod_log (actor, action, snapshot) VALUES ('admin', 'delete_post', ?)`, s.before); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("MigrateUp() error = %v", err)
+	}
+
+	rows, err := db.Query(`SELECT snapshot FROM mod_log ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var snapshot string
+		if err := rows.Scan(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, snapshot)
+	}
+	if len(got) != len(snapshots) {
+		t.Fatalf("got %d snapshots, want %d", len(got), len(snapshots))
+	}
+	for i, s := range snapshots {
+		if got[i] != s.after {
+			t.Errorf("snapshot %d = %s, want %s", i, got[i], s.after)
+		}
+	}
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS bans;
+DROP TABLE IF EXISTS admins;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS threads;
+DROP TABLE IF EXISTS boards;
@@ -1,9 +1,3 @@
-PRAGMA foreign_keys = ON;
-
--- ======================
--- Tables
--- ======================
-
 CREATE TABLE IF NOT EXISTS boards (
     id INTEGER PRIMARY KEY,
     slug TEXT NOT NULL UNIQUE,
@@ -53,12 +47,13 @@ CREATE TABLE IF NOT EXISTS bans (
 -- Seed data
 -- ======================
 
--- Boards
+-- databases created by the old seed.sql already contain these rows
 
-INSERT INTO boards (slug, name, tag) VALUES 
+INSERT OR IGNORE INTO boards (slug, name, tag) VALUES 
     ('c', 'Comfy', 'Be comfy, fren'),
     ('r', 'Robots', 'Beep, boop'),
     ('gn', 'Goon', 'God is watching');
 
-INSERT INTO admins (username, password) VALUES
-    ('admin', '$2a$10$vRP4/9O6SwyUziEUtBLQM.r9C2WujIIZ6yEgqGjhlBaFPvtpfdHPC');
+INSERT INTO admins (username, password)
+SELECT 'admin', '$2a$10$vRP4/9O6SwyUziEUtBLQM.r9C2WujIIZ6yEgqGjhlBaFPvtpfdHPC'
+WHERE NOT EXISTS (SELECT 1 FROM admins);
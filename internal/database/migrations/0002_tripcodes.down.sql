@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS secrets;
+
+ALTER TABLE posts DROP COLUMN tripcode;
@@ -0,0 +1,7 @@
+ALTER TABLE posts ADD COLUMN tripcode TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS secrets (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
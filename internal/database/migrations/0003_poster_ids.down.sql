@@ -0,0 +1,3 @@
+ALTER TABLE posts DROP COLUMN poster_id;
+
+ALTER TABLE boards DROP COLUMN show_poster_ids;
@@ -0,0 +1,3 @@
+ALTER TABLE boards ADD COLUMN show_poster_ids BOOLEAN NOT NULL DEFAULT 0;
+
+ALTER TABLE posts ADD COLUMN poster_id TEXT NOT NULL DEFAULT '';
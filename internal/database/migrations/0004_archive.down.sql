@@ -0,0 +1,5 @@
+-- archived threads go back to their boards. deleting them here would leave
+-- their media in the store with nothing left to remove it
+ALTER TABLE threads DROP COLUMN archived_at;
+
+ALTER TABLE threads DROP COLUMN archived;
@@ -0,0 +1,3 @@
+ALTER TABLE threads ADD COLUMN archived BOOLEAN NOT NULL DEFAULT 0;
+
+ALTER TABLE threads ADD COLUMN archived_at DATETIME;
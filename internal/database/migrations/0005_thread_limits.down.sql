@@ -0,0 +1,3 @@
+ALTER TABLE boards DROP COLUMN image_limit;
+
+ALTER TABLE boards DROP COLUMN bump_limit;
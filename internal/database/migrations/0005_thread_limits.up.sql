@@ -0,0 +1,4 @@
+-- 0 disables the limit
+ALTER TABLE boards ADD COLUMN bump_limit INTEGER NOT NULL DEFAULT 300;
+
+ALTER TABLE boards ADD COLUMN image_limit INTEGER NOT NULL DEFAULT 150;
@@ -0,0 +1 @@
+ALTER TABLE posts ADD COLUMN sage BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+ALTER TABLE boards DROP COLUMN max_threads;
+
+ALTER TABLE boards DROP COLUMN nsfw;
+
+ALTER TABLE boards DROP COLUMN banner_path;
@@ -0,0 +1,7 @@
+-- empty banner_path falls back to /static/media/banners/{slug}.png
+ALTER TABLE boards ADD COLUMN banner_path TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE boards ADD COLUMN nsfw BOOLEAN NOT NULL DEFAULT 0;
+
+-- 0 falls back to the global thread limit
+ALTER TABLE boards ADD COLUMN max_threads INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,18 @@
+-- only the first file of each post survives the rollback
+ALTER TABLE posts ADD COLUMN media_path TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE posts ADD COLUMN thumb_path TEXT NOT NULL DEFAULT '';
+
+UPDATE posts
+SET media_path = COALESCE((
+        SELECT media_path FROM post_files
+        WHERE post_id = posts.id
+        ORDER BY position LIMIT 1), ''),
+    thumb_path = COALESCE((
+        SELECT thumb_path FROM post_files
+        WHERE post_id = posts.id
+        ORDER BY position LIMIT 1), '');
+
+DROP INDEX IF EXISTS idx_post_files_post_id;
+
+DROP TABLE IF EXISTS post_files;
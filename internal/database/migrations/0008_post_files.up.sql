@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS post_files (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    media_path TEXT NOT NULL DEFAULT '',
+    thumb_path TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_files_post_id ON post_files(post_id, position);
+
+INSERT INTO post_files (post_id, position, media_path, thumb_path)
+SELECT id, 0, media_path, thumb_path
+FROM posts
+WHERE media_path != '';
+
+ALTER TABLE posts DROP COLUMN media_path;
+
+ALTER TABLE posts DROP COLUMN thumb_path;
@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS banned_hashes;
+
+ALTER TABLE boards DROP COLUMN duplicate_window;
+
+DROP INDEX IF EXISTS idx_post_files_media_path;
+
+DROP INDEX IF EXISTS idx_post_files_sha256;
+
+ALTER TABLE post_files DROP COLUMN phash;
+
+ALTER TABLE post_files DROP COLUMN sha256;
@@ -0,0 +1,19 @@
+-- files uploaded before this migration have no hashes and are never deduplicated
+ALTER TABLE post_files ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE post_files ADD COLUMN phash TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_post_files_sha256 ON post_files(sha256);
+
+CREATE INDEX IF NOT EXISTS idx_post_files_media_path ON post_files(media_path);
+
+-- minutes an exact duplicate is rejected for. 0 allows duplicates
+ALTER TABLE boards ADD COLUMN duplicate_window INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS banned_hashes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    sha256 TEXT NOT NULL UNIQUE,
+    phash TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
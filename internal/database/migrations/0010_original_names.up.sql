@@ -0,0 +1,2 @@
+-- display name of the uploaded file. stored files are named by the server
+ALTER TABLE post_files ADD COLUMN original_name TEXT NOT NULL DEFAULT '';
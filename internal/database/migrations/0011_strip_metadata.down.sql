@@ -0,0 +1,3 @@
+ALTER TABLE post_files DROP COLUMN metadata_stripped;
+
+ALTER TABLE boards DROP COLUMN strip_metadata;
@@ -0,0 +1,5 @@
+ALTER TABLE boards ADD COLUMN strip_metadata BOOLEAN NOT NULL DEFAULT 1;
+
+-- whether the stored file had its metadata removed, so deduplicated uploads
+-- on boards that strip never reuse a file that still carries it
+ALTER TABLE post_files ADD COLUMN metadata_stripped BOOLEAN NOT NULL DEFAULT 0;
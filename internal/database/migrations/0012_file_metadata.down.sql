@@ -0,0 +1,5 @@
+ALTER TABLE post_files DROP COLUMN duration_ms;
+ALTER TABLE post_files DROP COLUMN height;
+ALTER TABLE post_files DROP COLUMN width;
+ALTER TABLE post_files DROP COLUMN size;
+ALTER TABLE post_files DROP COLUMN mime_type;
@@ -0,0 +1,7 @@
+-- file details computed once at upload instead of on every render. rows with
+-- an empty mime_type predate this and are filled by `comfychan media backfill`
+ALTER TABLE post_files ADD COLUMN mime_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE post_files ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE post_files ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE post_files ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE post_files ADD COLUMN duration_ms INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_media_jobs_status;
+
+DROP TABLE IF EXISTS media_jobs;
+
+ALTER TABLE post_files DROP COLUMN media_status;
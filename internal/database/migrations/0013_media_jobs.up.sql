@@ -0,0 +1,18 @@
+-- 'ready', 'pending' while a media job processes the upload, or 'failed'
+ALTER TABLE post_files ADD COLUMN media_status TEXT NOT NULL DEFAULT 'ready';
+
+-- video processing runs in a bounded worker pool instead of the request.
+-- media_path is the stored name shared by every post_files row of the upload
+CREATE TABLE IF NOT EXISTS media_jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    media_path TEXT NOT NULL,
+    strip_metadata BOOLEAN NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending', -- pending, running, done or failed
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    run_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_jobs_status ON media_jobs(status, run_at);
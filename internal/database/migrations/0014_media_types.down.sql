@@ -0,0 +1 @@
+ALTER TABLE boards DROP COLUMN allowed_media_types;
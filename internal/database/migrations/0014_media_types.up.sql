@@ -0,0 +1,2 @@
+-- comma separated media types accepted by the board: image, video, audio, pdf
+ALTER TABLE boards ADD COLUMN allowed_media_types TEXT NOT NULL DEFAULT 'image,video';
@@ -0,0 +1 @@
+ALTER TABLE post_files DROP COLUMN preview_path;
@@ -0,0 +1,2 @@
+-- animated webp preview of videos and gifs, empty when there is none
+ALTER TABLE post_files ADD COLUMN preview_path TEXT NOT NULL DEFAULT '';
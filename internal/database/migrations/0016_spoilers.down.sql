@@ -0,0 +1,3 @@
+ALTER TABLE boards DROP COLUMN spoiler_path;
+
+ALTER TABLE posts DROP COLUMN spoiler;
@@ -0,0 +1,4 @@
+ALTER TABLE posts ADD COLUMN spoiler BOOLEAN NOT NULL DEFAULT 0;
+
+-- file name under media/spoilers, empty for the default spoiler image
+ALTER TABLE boards ADD COLUMN spoiler_path TEXT NOT NULL DEFAULT '';
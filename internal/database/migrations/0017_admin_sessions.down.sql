@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_admin_sessions_username;
+
+DROP TABLE IF EXISTS admin_sessions;
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS staff_invites;
+
+DROP TABLE IF EXISTS admin_boards;
+
+DROP INDEX IF EXISTS idx_admins_username;
+
+ALTER TABLE admins DROP COLUMN role;
@@ -0,0 +1,24 @@
+-- every existing admin had full access, so they become owners
+ALTER TABLE admins ADD COLUMN role TEXT NOT NULL DEFAULT 'owner';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admins_username ON admins(username);
+
+-- the boards a janitor moderates
+CREATE TABLE IF NOT EXISTS admin_boards (
+    admin_id INTEGER NOT NULL,
+    board_slug TEXT NOT NULL,
+    PRIMARY KEY (admin_id, board_slug),
+    FOREIGN KEY (admin_id) REFERENCES admins(id) ON DELETE CASCADE,
+    FOREIGN KEY (board_slug) REFERENCES boards(slug) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+-- links for joining the staff. only a hash of the token is stored
+CREATE TABLE IF NOT EXISTS staff_invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL,
+    boards TEXT NOT NULL DEFAULT '', -- comma separated slugs, for janitors
+    created_by TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_mod_log_created_at;
+
+DROP TABLE IF EXISTS mod_log;
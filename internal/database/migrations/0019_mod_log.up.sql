@@ -0,0 +1,15 @@
+-- every moderation action, kept after its target is gone. snapshot holds the
+-- JSON of deleted content so mistakes can be traced and undone by hand
+CREATE TABLE IF NOT EXISTS mod_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor TEXT NOT NULL, -- username of the staff member
+    action TEXT NOT NULL,
+    board_slug TEXT NOT NULL DEFAULT '',
+    thread_id INTEGER NOT NULL DEFAULT 0, -- 0 when not about a thread
+    post_number INTEGER NOT NULL DEFAULT 0, -- 0 when not about a post
+    reason TEXT NOT NULL DEFAULT '',
+    snapshot TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_mod_log_created_at ON mod_log(created_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_reports_post_ip;
+
+DROP TABLE IF EXISTS reports;
@@ -0,0 +1,14 @@
+-- users flagging posts for staff. removed with their post, or when staff
+-- dismiss them
+CREATE TABLE IF NOT EXISTS reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id INTEGER NOT NULL,
+    category TEXT NOT NULL,
+    comment TEXT NOT NULL DEFAULT '',
+    ip_hash TEXT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
+);
+
+-- an ip reports a post once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_post_ip ON reports(post_id, ip_hash);
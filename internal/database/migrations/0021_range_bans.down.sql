@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS range_bans;
+
+ALTER TABLE posts DROP COLUMN ip_range_hash;
@@ -0,0 +1,10 @@
+-- keyed hash of the poster's ip range, see util.HashIpRange. empty for posts
+-- made before range bans
+ALTER TABLE posts ADD COLUMN ip_range_hash TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS range_bans (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    range_hash TEXT NOT NULL UNIQUE,
+    reason TEXT NOT NULL,
+    expiration DATETIME NOT NULL
+);
@@ -0,0 +1,9 @@
+-- media no post references anymore. SweepReleasedMedia deletes it once it has
+-- stayed unreferenced for a while, so an upload deduplicated against it just
+-- before its last post was deleted can still claim it
+CREATE TABLE IF NOT EXISTS released_media (
+    media_path TEXT PRIMARY KEY,
+    thumb_path TEXT NOT NULL DEFAULT '',
+    preview_path TEXT NOT NULL DEFAULT '',
+    released_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
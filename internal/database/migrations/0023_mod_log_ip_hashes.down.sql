@@ -0,0 +1 @@
+-- the removed ip hashes can't be restored
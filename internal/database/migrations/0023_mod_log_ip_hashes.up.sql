@@ -0,0 +1,18 @@
+-- post snapshots no longer keep the poster's ip hashes. a plain sha256 of an
+-- ip is easily reversed, and the log is kept forever
+UPDATE mod_log
+SET snapshot = json_remove(snapshot, '$.ip_hash', '$.ip_range_hash')
+WHERE json_valid(snapshot) AND json_type(snapshot, '$.ip_hash') IS NOT NULL;
+
+-- bans
+UPDATE mod_log
+SET snapshot = json_remove(snapshot, '$.post.ip_hash', '$.post.ip_range_hash')
+WHERE json_valid(snapshot) AND json_type(snapshot, '$.post.ip_hash') IS NOT NULL;
+
+-- deleted threads
+UPDATE mod_log
+SET snapshot = json_set(snapshot, '$.posts', (
+    SELECT json_group_array(json_remove(post.value, '$.ip_hash', '$.ip_range_hash'))
+    FROM json_each(snapshot, '$.posts') AS post
+))
+WHERE json_valid(snapshot) AND json_type(snapshot, '$.posts') = 'array';
@@ -0,0 +1 @@
+-- renamed usernames are kept, and the index belongs to 0018
@@ -0,0 +1,15 @@
+-- usernames were never unique before 0018 indexed them. rebuild the index
+-- over deduplicated names, so a database that lost it still ends up with
+-- unique usernames. the oldest account keeps its name and later duplicates
+-- get their id appended, so they can still log in under a name owners can
+-- see on the staff page
+DROP INDEX IF EXISTS idx_admins_username;
+
+UPDATE admins
+SET username = username || '-' || id
+WHERE EXISTS (
+    SELECT 1 FROM admins AS older
+    WHERE older.username = admins.username AND older.id < admins.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admins_username ON admins(username);
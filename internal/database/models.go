@@ -1,44 +1,227 @@
 package database
 
-import "time"
+import (
+	"slices"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/util"
+)
 
 type Board struct {
-	Id   int
-	Slug string
-	Name string
-	Tag  string
+	Id            int
+	Slug          string
+	Name          string
+	Tag           string
+	ShowPosterIds bool
+	BumpLimit     int // 0 disables the limit
+	ImageLimit    int // 0 disables the limit
+	BannerPath    string
+	Nsfw          bool
+	MaxThreads    int // 0 falls back to the max_threads limit of the config
+	// minutes an exact duplicate upload is rejected for. 0 allows duplicates
+	DuplicateWindow   int
+	StripMetadata     bool
+	AllowedMediaTypes []util.PostMediaType
+	// shown in place of spoilered thumbnails. empty uses the default image
+	SpoilerPath string
 }
 
 type Thread struct {
-	Id        int
-	BoardSlug string
-	Subject   string
-	CreatedAt time.Time
-	BumpedAt  time.Time
-	Pinned    bool
-	Locked    bool
+	Id         int
+	BoardSlug  string
+	Subject    string
+	CreatedAt  time.Time
+	BumpedAt   time.Time
+	Pinned     bool
+	Locked     bool
+	Archived   bool
+	ArchivedAt time.Time
+}
+
+type ThreadStats struct {
+	ReplyCount int
+	ImageCount int
+}
+
+type ArchivedThread struct {
+	Thread
+	OpBody     string
+	ReplyCount int
 }
 
 type Post struct {
 	Id        int
 	ThreadId  int
 	Author    string
+	Tripcode  string
 	Body      string
 	CreatedAt time.Time
-	MediaPath string
-	ThumbPath string
+	Files     []PostFile
 	IpHash    string
 	Number    int
 	Banned    bool
+	PosterId  string
+	Sage      bool
+	Spoiler   bool // its files are hidden behind the board's spoiler image
+	// keyed hash of the poster's ip range, for range bans. empty for older posts
+	IpRangeHash string
+}
+
+type PostFile struct {
+	Id        int
+	PostId    int
+	Position  int
+	MediaPath string
+	ThumbPath string
+	// animated preview of videos and gifs. empty when previews are disabled
+	PreviewPath string
+	Sha256      string
+	Phash       string
+	// the client's file name, shown and used as the download name. empty for
+	// files uploaded before it was recorded
+	OriginalName     string
+	MetadataStripped bool
+	// recorded at upload. MimeType is empty until backfilled for older files
+	Info        util.PostFileInfo
+	MediaStatus string // one of the MediaStatus constants
+}
+
+const (
+	MediaStatusReady   = "ready"
+	MediaStatusPending = "pending" // a media job has not finished processing it yet
+	MediaStatusFailed  = "failed"
+)
+
+// a queued processing step for an uploaded video, see web/mediajobs.go
+type MediaJob struct {
+	Id            int
+	MediaPath     string
+	StripMetadata bool
+	Status        string // one of the MediaJobStatus constants
+	Attempts      int    // failed runs so far
+	LastError     string
+	CreatedAt     time.Time
+}
+
+const (
+	MediaJobPending = "pending"
+	MediaJobRunning = "running"
+	MediaJobDone    = "done"
+	MediaJobFailed  = "failed"
+)
+
+type BannedHash struct {
+	Id        int
+	Sha256    string
+	Phash     string
+	Reason    string
+	CreatedAt time.Time
 }
 
 type Admin struct {
+	Id       int
 	Username string
 	Password string
+	Role     util.StaffRole
+	Boards   []string // slugs of the boards a board scoped role moderates
+}
+
+// whether the admin's role applies to the board at boardSlug
+func (a Admin) Moderates(boardSlug string) bool {
+	return !a.Role.IsBoardScoped() || slices.Contains(a.Boards, boardSlug)
+}
+
+// whether the admin may use p on the board at boardSlug
+func (a Admin) Can(p util.Permission, boardSlug string) bool {
+	return a.Role.Can(p) && a.Moderates(boardSlug)
+}
+
+// a link for joining the staff with the given role
+type StaffInvite struct {
+	Id        int
+	Role      util.StaffRole
+	Boards    []string
+	CreatedBy string // username of the inviter
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type AdminSession struct {
+	Id         int
+	Username   string
+	IpHash     string // of the ip that logged in
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// a moderation action recorded in the mod log
+type ModLogEntry struct {
+	Id         int
+	Actor      string // username of the staff member
+	Action     string // one of the ModAction constants
+	BoardSlug  string
+	ThreadId   int // 0 when not about a thread
+	PostNumber int // 0 when not about a post
+	Reason     string
+	Snapshot   string // JSON of the content the action removed, if any
+	CreatedAt  time.Time
+}
+
+const (
+	ModActionDeleteThread   = "delete_thread"
+	ModActionDeletePost     = "delete_post"
+	ModActionBanIp          = "ban_ip"
+	ModActionBanIpRange     = "ban_ip_range"
+	ModActionBanFile        = "ban_file"
+	ModActionUnbanFile      = "unban_file"
+	ModActionPin            = "pin"
+	ModActionUnpin          = "unpin"
+	ModActionLock           = "lock"
+	ModActionUnlock         = "unlock"
+	ModActionDismissReports = "dismiss_reports"
+)
+
+// every action, in the order they are offered as filters
+var MOD_ACTIONS = []string{
+	ModActionDeleteThread, ModActionDeletePost, ModActionBanIp, ModActionBanIpRange, ModActionBanFile,
+	ModActionUnbanFile, ModActionPin, ModActionUnpin, ModActionLock, ModActionUnlock,
+	ModActionDismissReports,
+}
+
+// a user's flag on a post
+type Report struct {
+	Id        int
+	PostId    int
+	Category  string // one of the ReportCategory constants
+	Comment   string
+	IpHash    string
+	CreatedAt time.Time
+}
+
+const (
+	ReportCategoryRules   = "rules" // breaks the board's rules
+	ReportCategorySpam    = "spam"
+	ReportCategoryIllegal = "illegal"
+	ReportCategoryOther   = "other"
+)
+
+// every category, in the order they are offered to reporters
+var REPORT_CATEGORIES = []string{
+	ReportCategoryRules, ReportCategorySpam, ReportCategoryIllegal, ReportCategoryOther,
+}
+
+// a post in the moderation queue, along with all of its reports
+type ReportedPost struct {
+	Post      Post
+	BoardSlug string
+	IsOp      bool // deleting it deletes the thread
+	Reports   []Report
 }
 
 type Ban struct {
-	IpHash     string
+	IpHash     string // the range hash for range bans
 	Reason     string
 	Expiration time.Time
 }
@@ -1,55 +1,35 @@
 package util
 
 import (
-	"sync"
-	"time"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
 )
 
-type AdminSession struct {
-	Expiration time.Time
-	Username   string
-}
-
-var (
-	AdminSessions = make(map[string]AdminSession)
-	AdminMutex    = sync.RWMutex{}
-)
+const ADMIN_SESSION_COOKIE = "comfy_admin"
 
-func IsAdminSessionValid(token string) bool {
-	AdminMutex.RLock()
-	session, exists := AdminSessions[token]
-	AdminMutex.RUnlock()
-
-	if !exists || time.Now().After(session.Expiration) {
-		AdminMutex.Lock()
-		delete(AdminSessions, token)
-		AdminMutex.Unlock()
-		return false
-	}
-
-	return true
+// only hashes of session and invite tokens are stored, so a copy of the
+// database can't be used to log in
+func HashToken(token string) string {
+	checksum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(checksum[:])
 }
 
-func CreateAdminSession(token string, session AdminSession) {
-	AdminMutex.Lock()
-	AdminSessions[token] = session
-	AdminMutex.Unlock()
-}
+const MAX_STAFF_USERNAME_LEN = 32
+const MIN_STAFF_PASSWORD_LEN = 8
 
-func DeleteAdminSession(token string) {
-	AdminMutex.Lock()
-	delete(AdminSessions, token)
-	AdminMutex.Unlock()
-}
+var staffUsernameRx = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
-func HasExistingAdminSession(username string) bool {
-	AdminMutex.RLock()
-	defer AdminMutex.RUnlock()
-	for _, session := range AdminSessions {
-		if session.Username == username {
-			return true
-		}
+func ValidateStaffUsername(username string) error {
+	switch {
+	case username == "":
+		return errors.New("Username is required")
+	case len(username) > MAX_STAFF_USERNAME_LEN:
+		return fmt.Errorf("Username exceeds %d characters", MAX_STAFF_USERNAME_LEN)
+	case !staffUsernameRx.MatchString(username):
+		return errors.New("Username may only contain letters, numbers, _ and -")
 	}
-
-	return false
+	return nil
 }
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var BANNER_MEDIA_PATH = "media/banners"
+var SPOILER_MEDIA_PATH = "media/spoilers"
+
+const MAX_BOARD_SLUG_LEN = 10
+const MAX_BOARD_NAME_LEN = 50
+const MAX_BOARD_TAG_LEN = 100
+
+// slugs that would be shadowed by other routes
+var RESERVED_BOARD_SLUGS = []string{"admin", "api", "authorize", "files", "hx", "invite", "media", "modlog", "reports", "static"}
+
+var boardSlugRx = regexp.MustCompile(`^[a-z0-9]+$`)
+
+func ValidateBoardSlug(slug string) error {
+	switch {
+	case slug == "":
+		return errors.New("Slug is empty")
+	case len(slug) > MAX_BOARD_SLUG_LEN:
+		return fmt.Errorf("Slug exceeds %d characters", MAX_BOARD_SLUG_LEN)
+	case !boardSlugRx.MatchString(slug):
+		return errors.New("Slug may only contain lowercase letters and digits")
+	case slices.Contains(RESERVED_BOARD_SLUGS, slug):
+		return fmt.Errorf("Slug %q is reserved", slug)
+	}
+	return nil
+}
+
+// saves an uploaded banner image and returns its file name under BANNER_MEDIA_PATH
+func SaveBannerFile(file multipart.File, slug string, originalName string) (string, error) {
+	return saveBoardImage(file, BANNER_MEDIA_PATH, slug, originalName)
+}
+
+func DeleteBannerFile(fileName string) error {
+	return deleteBoardImage(BANNER_MEDIA_PATH, fileName)
+}
+
+// saves an uploaded spoiler image, shown in place of spoilered thumbnails, and
+// returns its file name under SPOILER_MEDIA_PATH
+func SaveSpoilerFile(file multipart.File, slug string, originalName string) (string, error) {
+	return saveBoardImage(file, SPOILER_MEDIA_PATH, slug, originalName)
+}
+
+func DeleteSpoilerFile(fileName string) error {
+	return deleteBoardImage(SPOILER_MEDIA_PATH, fileName)
+}
+
+func saveBoardImage(file multipart.File, dir string, slug string, originalName string) (string, error) {
+	mediaType, err := DetectPostFileType(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if mediaType != PostFileImage {
+		return "", errors.New("not a supported image")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	fileName := slug + "-" + strconv.FormatInt(time.Now().UnixNano(), 10) +
+		strings.ToLower(filepath.Ext(originalName))
+
+	dst, err := os.Create(filepath.Join(dir, fileName))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, file); err != nil {
+		return "", err
+	}
+
+	return fileName, nil
+}
+
+func deleteBoardImage(dir string, fileName string) error {
+	if fileName == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(dir, fileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
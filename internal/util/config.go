@@ -0,0 +1,373 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// settings operators can change without recompiling. read from a TOML file,
+// see comfychan.example.toml, then overridden by COMFYCHAN_* environment
+// variables. reloaded on SIGHUP, except for the ones noted
+type Config struct {
+	Listen string `toml:"listen"` // changes need a restart
+	// gives every visitor owner access, so listen must be a loopback address.
+	// only read at startup
+	DevMode bool `toml:"dev_mode"`
+	// admins are logged out after going this long without a request
+	AdminSessionTimeout time.Duration `toml:"admin_session_timeout"`
+	// lists moderation actions at /modlog without saying who took them
+	PublicModLog bool   `toml:"public_mod_log"`
+	Limits       Limits `toml:"limits"`
+	Media        Media  `toml:"media"`
+	// how long archived threads are kept before they are deleted along with
+	// their posts. a negative value keeps them forever
+	ArchivedThreadRetention time.Duration `toml:"archived_thread_retention"`
+	// overrides of Limits keyed by board slug
+	Boards map[string]BoardLimits `toml:"boards"`
+}
+
+type Limits struct {
+	PostCooldown   time.Duration `toml:"post_cooldown"`
+	ThreadCooldown time.Duration `toml:"thread_cooldown"`
+	// how long an ip waits between reporting posts
+	ReportCooldown time.Duration `toml:"report_cooldown"`
+	// boards with their own max threads set in the admin panel use that instead
+	MaxThreads      int      `toml:"max_threads"`
+	MaxBodyLen      int      `toml:"max_body_len"`
+	MaxSubjectLen   int      `toml:"max_subject_len"`
+	MaxFileSize     ByteSize `toml:"max_file_size"`
+	MaxFilesPerPost int      `toml:"max_files_per_post"`
+}
+
+// a board's overrides of Limits. nil fields use the global value
+type BoardLimits struct {
+	PostCooldown    *time.Duration `toml:"post_cooldown"`
+	ThreadCooldown  *time.Duration `toml:"thread_cooldown"`
+	ReportCooldown  *time.Duration `toml:"report_cooldown"`
+	MaxThreads      *int           `toml:"max_threads"`
+	MaxBodyLen      *int           `toml:"max_body_len"`
+	MaxSubjectLen   *int           `toml:"max_subject_len"`
+	MaxFileSize     *ByteSize      `toml:"max_file_size"`
+	MaxFilesPerPost *int           `toml:"max_files_per_post"`
+}
+
+type Media struct {
+	Workers int `toml:"workers"` // changes need a restart
+	// how long archived threads keep their media. 0 removes it at the next
+	// purge, a negative value keeps it forever
+	ArchiveRetention time.Duration `toml:"archive_retention"`
+	// images larger than this on either side are downscaled and re-encoded on
+	// upload. 0 keeps every image at its original size
+	MaxImageDimension int `toml:"max_image_dimension"`
+	// make short looping webp previews of videos and animated gifs, played
+	// when hovering their thumbnail. needs an ffmpeg built with libwebp
+	AnimatedPreviews bool `toml:"animated_previews"`
+	// where post media is kept, "local" for the data directory or "s3" for
+	// the bucket in S3. changes need a restart
+	Store string   `toml:"store"`
+	S3    S3Config `toml:"s3"`
+}
+
+// an S3 compatible bucket holding post media when media.store is "s3"
+type S3Config struct {
+	Endpoint  string `toml:"endpoint"`
+	Bucket    string `toml:"bucket"`
+	Region    string `toml:"region"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	// serves the bucket's objects to visitors, e.g. a CDN. empty links
+	// straight to the bucket
+	PublicURL string `toml:"public_url"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:                  "0.0.0.0:7676",
+		AdminSessionTimeout:     time.Hour,
+		ArchivedThreadRetention: 30 * 24 * time.Hour,
+		Limits: Limits{
+			PostCooldown:    15 * time.Second,
+			ThreadCooldown:  2 * time.Minute,
+			ReportCooldown:  time.Minute,
+			MaxThreads:      50,
+			MaxBodyLen:      3000,
+			MaxSubjectLen:   50,
+			MaxFileSize:     10 << 20,
+			MaxFilesPerPost: 4,
+		},
+		Media: Media{
+			Workers:           2,
+			ArchiveRetention:  7 * 24 * time.Hour,
+			MaxImageDimension: 4096,
+			Store:             "local",
+			S3:                S3Config{Region: "us-east-1"},
+		},
+	}
+}
+
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+	currentConfig.Store(DefaultConfig())
+}
+
+// the config in effect. callers should not modify it
+func GetConfig() *Config {
+	return currentConfig.Load()
+}
+
+func SetConfig(c *Config) {
+	currentConfig.Store(c)
+}
+
+// reads the config file at path over the defaults and applies the COMFYCHAN_*
+// environment variables. a missing file leaves the defaults
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+
+	md, err := toml.DecodeFile(path, c)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	// most likely a typo, which would otherwise be silently ignored
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%s: unknown setting %q", path, undecoded[0].String())
+	}
+
+	for _, env := range configEnv {
+		value, ok := os.LookupEnv(env.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := env.apply(c, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", env.name, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var configEnv = []struct {
+	name  string
+	apply func(c *Config, value string) error
+}{
+	{"COMFYCHAN_LISTEN", envSetter(parseString, func(c *Config) *string { return &c.Listen })},
+	{"COMFYCHAN_DEV_MODE", envSetter(strconv.ParseBool, func(c *Config) *bool { return &c.DevMode })},
+	{"COMFYCHAN_ADMIN_SESSION_TIMEOUT", envSetter(time.ParseDuration, func(c *Config) *time.Duration { return &c.AdminSessionTimeout })},
+	{"COMFYCHAN_PUBLIC_MOD_LOG", envSetter(strconv.ParseBool, func(c *Config) *bool { return &c.PublicModLog })},
+	{"COMFYCHAN_ARCHIVED_THREAD_RETENTION", envSetter(time.ParseDuration, func(c *Config) *time.Duration { return &c.ArchivedThreadRetention })},
+	{"COMFYCHAN_POST_COOLDOWN", envSetter(time.ParseDuration, func(c *Config) *time.Duration { return &c.Limits.PostCooldown })},
+	{"COMFYCHAN_THREAD_COOLDOWN", envSetter(time.ParseDuration, func(c *Config) *time.Duration { return &c.Limits.ThreadCooldown })},
+	{"COMFYCHAN_REPORT_COOLDOWN", envSetter(time.ParseDuration, func(c *Config) *time.Duration { return &c.Limits.ReportCooldown })},
+	{"COMFYCHAN_MAX_THREADS", envSetter(strconv.Atoi, func(c *Config) *int { return &c.Limits.MaxThreads })},
+	{"COMFYCHAN_MAX_BODY_LEN", envSetter(strconv.Atoi, func(c *Config) *int { return &c.Limits.MaxBodyLen })},
+	{"COMFYCHAN_MAX_SUBJECT_LEN", envSetter(strconv.Atoi, func(c *Config) *int { return &c.Limits.MaxSubjectLen })},
+	{"COMFYCHAN_MAX_FILE_SIZE", envSetter(ParseByteSize, func(c *Config) *ByteSize { return &c.Limits.MaxFileSize })},
+	{"COMFYCHAN_MAX_FILES_PER_POST", envSetter(strconv.Atoi, func(c *Config) *int { return &c.Limits.MaxFilesPerPost })},
+	{"COMFYCHAN_MEDIA_WORKERS", envSetter(strconv.Atoi, func(c *Config) *int { return &c.Media.Workers })},
+	{"COMFYCHAN_ARCHIVE_MEDIA_RETENTION", envSetter(time.ParseDuration, func(c *Config) *time.Duration { return &c.Media.ArchiveRetention })},
+	{"COMFYCHAN_MAX_IMAGE_DIMENSION", envSetter(strconv.Atoi, func(c *Config) *int { return &c.Media.MaxImageDimension })},
+	{"COMFYCHAN_ANIMATED_PREVIEWS", envSetter(strconv.ParseBool, func(c *Config) *bool { return &c.Media.AnimatedPreviews })},
+	{"COMFYCHAN_MEDIA_STORE", envSetter(parseString, func(c *Config) *string { return &c.Media.Store })},
+	{"COMFYCHAN_S3_ENDPOINT", envSetter(parseString, func(c *Config) *string { return &c.Media.S3.Endpoint })},
+	{"COMFYCHAN_S3_BUCKET", envSetter(parseString, func(c *Config) *string { return &c.Media.S3.Bucket })},
+	{"COMFYCHAN_S3_REGION", envSetter(parseString, func(c *Config) *string { return &c.Media.S3.Region })},
+	{"COMFYCHAN_S3_ACCESS_KEY", envSetter(parseString, func(c *Config) *string { return &c.Media.S3.AccessKey })},
+	{"COMFYCHAN_S3_SECRET_KEY", envSetter(parseString, func(c *Config) *string { return &c.Media.S3.SecretKey })},
+	{"COMFYCHAN_S3_PUBLIC_URL", envSetter(parseString, func(c *Config) *string { return &c.Media.S3.PublicURL })},
+}
+
+func envSetter[T any](parse func(string) (T, error), field func(c *Config) *T) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		v, err := parse(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = v
+		return nil
+	}
+}
+
+func parseString(s string) (string, error) {
+	return s, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return errors.New("listen is empty")
+	}
+	if c.DevMode && !isLoopbackAddr(c.Listen) {
+		return fmt.Errorf("dev_mode gives every visitor owner access, so listen must be a loopback address like 127.0.0.1:7676, not %q", c.Listen)
+	}
+	if c.AdminSessionTimeout < time.Minute {
+		return errors.New("admin_session_timeout must be at least 1m")
+	}
+	if err := c.Limits.validate("limits"); err != nil {
+		return err
+	}
+	if c.Media.Workers < 1 {
+		return errors.New("media.workers must be at least 1")
+	}
+	if c.Media.MaxImageDimension < 0 {
+		return errors.New("media.max_image_dimension must be at least 0")
+	}
+	switch c.Media.Store {
+	case "local":
+	case "s3":
+		if c.Media.S3.Endpoint == "" || c.Media.S3.Bucket == "" {
+			return errors.New("media.s3.endpoint and media.s3.bucket are required when media.store is \"s3\"")
+		}
+		if c.Media.S3.Region == "" {
+			return errors.New("media.s3.region is empty")
+		}
+	default:
+		return fmt.Errorf("media.store must be \"local\" or \"s3\", not %q", c.Media.Store)
+	}
+
+	for slug := range c.Boards {
+		if err := ValidateBoardSlug(slug); err != nil {
+			return fmt.Errorf("boards.%s: %w", slug, err)
+		}
+		limits := c.BoardLimits(slug)
+		if err := limits.validate("boards." + slug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isLoopbackAddr(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+func (l Limits) validate(section string) error {
+	checks := []struct {
+		key string
+		ok  bool
+		msg string
+	}{
+		{"post_cooldown", l.PostCooldown >= 0, "must not be negative"},
+		{"thread_cooldown", l.ThreadCooldown >= 0, "must not be negative"},
+		{"report_cooldown", l.ReportCooldown >= 0, "must not be negative"},
+		{"max_threads", l.MaxThreads >= 1, "must be at least 1"},
+		{"max_body_len", l.MaxBodyLen >= 1, "must be at least 1"},
+		{"max_subject_len", l.MaxSubjectLen >= 1, "must be at least 1"},
+		{"max_file_size", l.MaxFileSize >= 1<<10, "must be at least 1KB"},
+		{"max_files_per_post", l.MaxFilesPerPost >= 1, "must be at least 1"},
+	}
+	for _, check := range checks {
+		if !check.ok {
+			return fmt.Errorf("%s.%s %s", section, check.key, check.msg)
+		}
+	}
+	return nil
+}
+
+// the limits of the board at slug, with its overrides applied
+func (c *Config) BoardLimits(slug string) Limits {
+	l := c.Limits
+	b, ok := c.Boards[slug]
+	if !ok {
+		return l
+	}
+
+	override(&l.PostCooldown, b.PostCooldown)
+	override(&l.ThreadCooldown, b.ThreadCooldown)
+	override(&l.ReportCooldown, b.ReportCooldown)
+	override(&l.MaxThreads, b.MaxThreads)
+	override(&l.MaxBodyLen, b.MaxBodyLen)
+	override(&l.MaxSubjectLen, b.MaxSubjectLen)
+	override(&l.MaxFileSize, b.MaxFileSize)
+	override(&l.MaxFilesPerPost, b.MaxFilesPerPost)
+	return l
+}
+
+func override[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// the longest cooldowns of any board, after which a cooldown can be forgotten
+func (c *Config) LongestCooldowns() (post, thread, report time.Duration) {
+	post, thread, report = c.Limits.PostCooldown, c.Limits.ThreadCooldown, c.Limits.ReportCooldown
+	for slug := range c.Boards {
+		l := c.BoardLimits(slug)
+		post = max(post, l.PostCooldown)
+		thread = max(thread, l.ThreadCooldown)
+		report = max(report, l.ReportCooldown)
+	}
+	return post, thread, report
+}
+
+// the most a post form may send: every file at the size limit plus room for
+// the text fields
+func (l Limits) MaxRequestBytes() int64 {
+	return int64(l.MaxFilesPerPost)*int64(l.MaxFileSize) + (1 << 20)
+}
+
+// a number of bytes, written in config as e.g. 10MB, 512KB or 1048576
+type ByteSize int64
+
+func ParseByteSize(s string) (ByteSize, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	orig := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", orig)
+	}
+	return ByteSize(n * multiplier), nil
+}
+
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func (b ByteSize) String() string {
+	return FormatBytes(int64(b))
+}
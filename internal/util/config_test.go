@@ -0,0 +1,69 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateMediaStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		media   func(m *Media)
+		wantErr string
+	}{
+		{"local", func(m *Media) {}, ""},
+		{"s3", func(m *Media) {
+			m.Store = "s3"
+			m.S3.Endpoint = "https://s3.example.com"
+			m.S3.Bucket = "media"
+		}, ""},
+		{"s3 without endpoint", func(m *Media) {
+			m.Store = "s3"
+			m.S3.Bucket = "media"
+		}, "media.s3.endpoint"},
+		{"s3 without bucket", func(m *Media) {
+			m.Store = "s3"
+			m.S3.Endpoint = "https://s3.example.com"
+		}, "media.s3.bucket"},
+		{"unknown store", func(m *Media) { m.Store = "ftp" }, "media.store"},
+		{"empty store", func(m *Media) { m.Store = "" }, "media.store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tt.media(&c.Media)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want one mentioning %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMediaStoreEnv(t *testing.T) {
+	t.Setenv("COMFYCHAN_MEDIA_STORE", "s3")
+	t.Setenv("COMFYCHAN_S3_ENDPOINT", "https://s3.example.com")
+	t.Setenv("COMFYCHAN_S3_BUCKET", "media")
+
+	c, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := S3Config{Endpoint: "https://s3.example.com", Bucket: "media", Region: "us-east-1"}
+	if c.Media.Store != "s3" || c.Media.S3 != want {
+		t.Errorf("media = %q %+v, want %q %+v", c.Media.Store, c.Media.S3, "s3", want)
+	}
+
+	t.Setenv("COMFYCHAN_S3_BUCKET", "")
+	t.Setenv("COMFYCHAN_S3_ENDPOINT", "")
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.toml")); err == nil {
+		t.Error("LoadConfig() accepted the s3 store without an endpoint or bucket")
+	}
+}
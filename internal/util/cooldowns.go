@@ -8,14 +8,11 @@ import (
 var (
 	PostCooldowns   = make(map[string]time.Time)
 	ThreadCooldowns = make(map[string]time.Time)
+	ReportCooldowns = make(map[string]time.Time)
 )
 
 var CooldownMutex sync.RWMutex
 
-const POST_COOLDOWN = 15 * time.Second
-
-const THREAD_COOLDOWN = 2 * time.Minute
-
 func GetRemainingCooldown(ipHash string, m map[string]time.Time, duration time.Duration) time.Duration {
 	CooldownMutex.RLock()
 	last, exists := m[ipHash]
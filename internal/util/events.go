@@ -0,0 +1,69 @@
+package util
+
+import (
+	"log"
+	"sync"
+)
+
+type ThreadEventType string
+
+const (
+	ThreadEventPostCreated    ThreadEventType = "post-created"
+	ThreadEventPostDeleted    ThreadEventType = "post-deleted"
+	ThreadEventThreadUpdated  ThreadEventType = "thread-updated"
+	ThreadEventThreadDeleted  ThreadEventType = "thread-deleted"
+	ThreadEventThreadArchived ThreadEventType = "thread-archived"
+)
+
+type ThreadEvent struct {
+	Type       ThreadEventType
+	ThreadId   int
+	PostId     int
+	PostNumber int
+}
+
+// events buffered per subscriber before new ones are dropped
+const THREAD_EVENT_BUFFER = 16
+
+var (
+	ThreadSubscribers = make(map[int]map[chan ThreadEvent]struct{})
+	ThreadEventsMutex = sync.RWMutex{}
+)
+
+// returns a channel receiving every event published for threadId, and a
+// function that must be called to unsubscribe
+func SubscribeThreadEvents(threadId int) (<-chan ThreadEvent, func()) {
+	ch := make(chan ThreadEvent, THREAD_EVENT_BUFFER)
+
+	ThreadEventsMutex.Lock()
+	if ThreadSubscribers[threadId] == nil {
+		ThreadSubscribers[threadId] = make(map[chan ThreadEvent]struct{})
+	}
+	ThreadSubscribers[threadId][ch] = struct{}{}
+	ThreadEventsMutex.Unlock()
+
+	unsubscribe := func() {
+		ThreadEventsMutex.Lock()
+		delete(ThreadSubscribers[threadId], ch)
+		if len(ThreadSubscribers[threadId]) == 0 {
+			delete(ThreadSubscribers, threadId)
+		}
+		ThreadEventsMutex.Unlock()
+	}
+
+	return ch, unsubscribe
+}
+
+// never blocks. slow subscribers miss events rather than stall the publisher
+func PublishThreadEvent(event ThreadEvent) {
+	ThreadEventsMutex.RLock()
+	defer ThreadEventsMutex.RUnlock()
+
+	for ch := range ThreadSubscribers[event.ThreadId] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("Dropped %s event for thread %d: subscriber buffer full", event.Type, event.ThreadId)
+		}
+	}
+}
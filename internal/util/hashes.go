@@ -0,0 +1,85 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// max differing bits between two 64 bit perceptual hashes for an upload to
+// count as a banned image. enough to survive recompression and resizing
+const PHASH_BAN_DISTANCE = 10
+
+type PostFileHash struct {
+	Sha256 string
+	Phash  string // empty for videos
+}
+
+// hashes the contents of file and rewinds it
+func HashPostFile(file io.ReadSeeker) (PostFileHash, error) {
+	var result PostFileHash
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return PostFileHash{}, err
+	}
+	result.Sha256 = hex.EncodeToString(h.Sum(nil))
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return PostFileHash{}, err
+	}
+
+	// not every upload is an image, those simply get no perceptual hash
+	if img, _, err := image.Decode(file); err == nil {
+		result.Phash = PerceptualHash(img)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return PostFileHash{}, err
+	}
+
+	return result, nil
+}
+
+// difference hash: shrink to 9x8 grayscale and compare each pixel with its
+// right neighbour. returned as 16 hex characters, or empty for featureless
+// images which would otherwise match every other flat image
+func PerceptualHash(img image.Image) string {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := small.Pix[small.PixOffset(x, y)]
+			right := small.Pix[small.PixOffset(x+1, y)]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+
+	if hash == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// returns the number of differing bits, or -1 if either hash is missing or malformed
+func PerceptualHashDistance(a, b string) int {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return -1
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
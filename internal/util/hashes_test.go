@@ -0,0 +1,109 @@
+package util
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// blocks of pseudo random shades, about the size of the 9x8 grid the hash
+// samples, so its bits are a mix of ones and zeros
+func blocksImage(w, h int) image.Image {
+	rng := rand.New(rand.NewPCG(1, 2))
+	shades := make([]uint8, 9*8)
+	for i := range shades {
+		shades[i] = uint8(rng.IntN(256))
+	}
+
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: shades[(y*8/h)*9+x*9/w]})
+		}
+	}
+	return img
+}
+
+func recompressJpeg(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := blocksImage(288, 192)
+	originalHash := PerceptualHash(original)
+	if len(originalHash) != 16 {
+		t.Fatalf("PerceptualHash(original) = %q, want 16 hex characters", originalHash)
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+		// whether the image should count as the banned original
+		match bool
+	}{
+		{"identical", original, true},
+		{"downscaled", imaging.Resize(original, 72, 48, imaging.Lanczos), true},
+		{"upscaled", imaging.Resize(original, 1152, 768, imaging.Linear), true},
+		{"recompressed", recompressJpeg(t, original, 30), true},
+		{"mirrored", imaging.FlipH(original), false},
+		{"inverted", imaging.Invert(original), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := PerceptualHash(tt.img)
+			distance := PerceptualHashDistance(originalHash, hash)
+			if distance < 0 {
+				t.Fatalf("PerceptualHashDistance(%q, %q) = %d", originalHash, hash, distance)
+			}
+			if match := distance <= PHASH_BAN_DISTANCE; match != tt.match {
+				t.Errorf("distance %d to the original, match = %v, want %v", distance, match, tt.match)
+			}
+		})
+	}
+}
+
+func TestPerceptualHashFlatImage(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	if hash := PerceptualHash(img); hash != "" {
+		t.Errorf("PerceptualHash(flat) = %q, want empty", hash)
+	}
+}
+
+func TestPerceptualHashDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0123456789abcdef", "0123456789abcdef", 0},
+		{"0000000000000000", "0000000000000001", 1},
+		{"0000000000000000", "00000000000003ff", 10},
+		{"0000000000000000", "00000000000007ff", 11},
+		{"0000000000000000", "ffffffffffffffff", 64},
+		{"", "0123456789abcdef", -1},
+		{"0123456789abcdef", "", -1},
+		{"not a hash", "0123456789abcdef", -1},
+		{"123456789abcdef01", "0123456789abcdef", -1}, // too long for 64 bits
+	}
+	for _, tt := range tests {
+		if got := PerceptualHashDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("PerceptualHashDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
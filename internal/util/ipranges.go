@@ -0,0 +1,46 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+)
+
+// the prefix lengths range bans cover. addresses hopped to within a home or
+// mobile connection usually stay inside these
+const (
+	IPV4_BAN_PREFIX_LEN = 24
+	IPV6_BAN_PREFIX_LEN = 64
+)
+
+// the range ip falls in, e.g. "203.0.113.0/24". ok is false for unparsable ips
+func IpRange(ip string) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+
+	addr = addr.Unmap().WithZone("")
+	bits := IPV6_BAN_PREFIX_LEN
+	if addr.Is4() {
+		bits = IPV4_BAN_PREFIX_LEN
+	}
+
+	prefix, err := addr.Prefix(bits)
+	return prefix, err == nil
+}
+
+// keyed hash of the range ip falls in, so range bans can be matched without
+// storing ips. a plain hash of a range could be reversed by trying them all.
+// empty for unparsable ips
+func HashIpRange(ip string, key string) string {
+	prefix, ok := IpRange(ip)
+	if !ok {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(prefix.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
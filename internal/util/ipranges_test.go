@@ -0,0 +1,75 @@
+package util
+
+import "testing"
+
+func TestIpRange(t *testing.T) {
+	tests := []struct {
+		ip     string
+		want   string
+		wantOk bool
+	}{
+		{"203.0.113.57", "203.0.113.0/24", true},
+		{"203.0.113.0", "203.0.113.0/24", true},
+		{"::ffff:203.0.113.57", "203.0.113.0/24", true},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64", true},
+		{"2001:DB8:1:2::", "2001:db8:1:2::/64", true},
+		{"fe80::1:2:3:4%eth0", "fe80::/64", true},
+		{"::1", "::/64", true},
+		{"", "", false},
+		{"unknown", "", false},
+		{"203.0.113.57:1234", "", false},
+		{"203.0.113", "", false},
+		{"2001:db8::1/64", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := IpRange(tt.ip)
+		if ok != tt.wantOk {
+			t.Errorf("IpRange(%q) ok = %v, want %v", tt.ip, ok, tt.wantOk)
+			continue
+		}
+		if ok && got.String() != tt.want {
+			t.Errorf("IpRange(%q) = %s, want %s", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestHashIpRange(t *testing.T) {
+	const key = "secret"
+
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{"same /24", "203.0.113.1", "203.0.113.254", true},
+		{"next /24", "203.0.113.1", "203.0.114.1", false},
+		{"same /64", "2001:db8:1:2::1", "2001:db8:1:2:ffff:ffff:ffff:ffff", true},
+		{"next /64", "2001:db8:1:2::1", "2001:db8:1:3::1", false},
+		{"ipv4 mapped ipv6", "::ffff:203.0.113.1", "203.0.113.99", true},
+		{"zone", "fe80::1%eth0", "fe80::2", true},
+		{"different zones", "fe80::1%eth0", "fe80::1%wlan0", true},
+		{"ipv4 and ipv6", "0.0.0.1", "::1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := HashIpRange(tt.a, key), HashIpRange(tt.b, key)
+			if a == "" || b == "" {
+				t.Fatalf("HashIpRange() = %q, %q, want hashes", a, b)
+			}
+			if (a == b) != tt.equal {
+				t.Errorf("HashIpRange(%q) == HashIpRange(%q) is %v, want %v", tt.a, tt.b, a == b, tt.equal)
+			}
+		})
+	}
+
+	if HashIpRange("203.0.113.1", key) == HashIpRange("203.0.113.1", "other") {
+		t.Error("HashIpRange() does not depend on the key")
+	}
+
+	// a malformed X-Forwarded-For
+	for _, ip := range []string{"", "unknown", "203.0.113.1 203.0.113.2", "<script>"} {
+		if got := HashIpRange(ip, key); got != "" {
+			t.Errorf("HashIpRange(%q) = %q, want empty", ip, got)
+		}
+	}
+}
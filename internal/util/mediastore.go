@@ -0,0 +1,145 @@
+package util
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// where post media is kept. keys are slash separated paths such as
+// "posts/full/<name>"
+type MediaStore interface {
+	// stores r under key, replacing any existing object
+	Put(key string, r io.Reader, contentType string) error
+	// opens the object at key. missing objects return an error wrapping fs.ErrNotExist
+	Open(key string) (MediaObject, error)
+	// removes the object at key. missing objects are not an error
+	Delete(key string) error
+	// the url browsers load the object from
+	URL(key string) string
+}
+
+type MediaObject struct {
+	io.ReadCloser
+	Size int64 // -1 when the store does not know it
+}
+
+const (
+	POST_MEDIA_FULL_PREFIX  = "posts/full"
+	POST_MEDIA_THUMB_PREFIX = "posts/thumb"
+	// uploads waiting for a media job. not linked from any page
+	POST_MEDIA_PENDING_PREFIX = "posts/pending"
+	// animated webp previews of videos and gifs
+	POST_MEDIA_PREVIEW_PREFIX = "posts/preview"
+)
+
+// shown in place of thumbnails that have not been generated
+const PENDING_THUMB_URL = "/static/media/processing.svg"
+
+// root of the local media store, also serving banners
+var MEDIA_PATH = "media"
+
+var MEDIA_STORE MediaStore = &LocalMediaStore{Root: MEDIA_PATH, URLPrefix: "/media"}
+
+func PostMediaKey(name string) string {
+	return path.Join(POST_MEDIA_FULL_PREFIX, name)
+}
+
+func PostThumbKey(name string) string {
+	return path.Join(POST_MEDIA_THUMB_PREFIX, name)
+}
+
+func PostPendingKey(name string) string {
+	return path.Join(POST_MEDIA_PENDING_PREFIX, name)
+}
+
+func PostPreviewKey(name string) string {
+	return path.Join(POST_MEDIA_PREVIEW_PREFIX, name)
+}
+
+func PostMediaURL(name string) string {
+	return MEDIA_STORE.URL(PostMediaKey(name))
+}
+
+func PostThumbURL(name string) string {
+	if name == "" {
+		return PENDING_THUMB_URL
+	}
+	return MEDIA_STORE.URL(PostThumbKey(name))
+}
+
+// empty when the file has no preview
+func PostPreviewURL(name string) string {
+	if name == "" {
+		return ""
+	}
+	return MEDIA_STORE.URL(PostPreviewKey(name))
+}
+
+// stores media on the local filesystem. URLPrefix is where the app serves Root
+type LocalMediaStore struct {
+	Root      string
+	URLPrefix string
+}
+
+// confines key to the store root
+func (s *LocalMediaStore) filePath(key string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (s *LocalMediaStore) Put(key string, r io.Reader, contentType string) error {
+	dst := s.filePath(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// write next to the destination and rename, so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (s *LocalMediaStore) Open(key string) (MediaObject, error) {
+	file, err := os.Open(s.filePath(key))
+	if err != nil {
+		return MediaObject{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return MediaObject{}, err
+	}
+
+	return MediaObject{ReadCloser: file, Size: info.Size()}, nil
+}
+
+func (s *LocalMediaStore) Delete(key string) error {
+	err := os.Remove(s.filePath(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalMediaStore) URL(key string) string {
+	return strings.TrimSuffix(s.URLPrefix, "/") + "/" + strings.TrimPrefix(path.Clean("/"+key), "/")
+}
@@ -0,0 +1,202 @@
+package util
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var SUPPORTED_IMAGE_MIME_TYPES = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif"}
+var SUPPORTED_VIDEO_MIME_TYPES = []string{"video/webm", "video/mp4", "video/ogg"}
+var SUPPORTED_AUDIO_MIME_TYPES = []string{"audio/mpeg", "audio/flac", "audio/ogg"}
+var SUPPORTED_PDF_MIME_TYPES = []string{"application/pdf"}
+
+type PostMediaType int64
+
+const (
+	PostFileImage PostMediaType = iota
+	PostFileVideo
+	PostFileAudio
+	PostFilePdf
+	PostFileUnsupported
+)
+
+// every type a board can allow, in the order they are listed
+var POST_MEDIA_TYPES = []PostMediaType{PostFileImage, PostFileVideo, PostFileAudio, PostFilePdf}
+
+// what boards allow unless configured otherwise
+var DEFAULT_POST_MEDIA_TYPES = []PostMediaType{PostFileImage, PostFileVideo}
+
+var postMediaTypeNames = map[PostMediaType]string{
+	PostFileImage: "image",
+	PostFileVideo: "video",
+	PostFileAudio: "audio",
+	PostFilePdf:   "pdf",
+}
+
+// the name used in board settings and the API
+func (t PostMediaType) String() string {
+	if name, ok := postMediaTypeNames[t]; ok {
+		return name
+	}
+	return "unsupported"
+}
+
+func (t PostMediaType) MimeTypes() []string {
+	switch t {
+	case PostFileImage:
+		return SUPPORTED_IMAGE_MIME_TYPES
+	case PostFileVideo:
+		return SUPPORTED_VIDEO_MIME_TYPES
+	case PostFileAudio:
+		return SUPPORTED_AUDIO_MIME_TYPES
+	case PostFilePdf:
+		return SUPPORTED_PDF_MIME_TYPES
+	default:
+		return nil
+	}
+}
+
+// parses a comma separated list of media type names. unknown names are ignored
+func ParsePostMediaTypes(s string) []PostMediaType {
+	var result []PostMediaType
+	for _, name := range strings.Split(s, ",") {
+		for _, t := range POST_MEDIA_TYPES {
+			if t.String() == strings.TrimSpace(name) && !slices.Contains(result, t) {
+				result = append(result, t)
+			}
+		}
+	}
+	return result
+}
+
+func FormatPostMediaTypes(types []PostMediaType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return strings.Join(names, ",")
+}
+
+// the mime types of every media type in types, e.g. for a file input's accept
+func PostMediaMimeTypes(types []PostMediaType) []string {
+	var result []string
+	for _, t := range types {
+		result = append(result, t.MimeTypes()...)
+	}
+	return result
+}
+
+func PostFileMediaType(mimeType string) PostMediaType {
+	for _, t := range POST_MEDIA_TYPES {
+		if slices.Contains(t.MimeTypes(), mimeType) {
+			return t
+		}
+	}
+	return PostFileUnsupported
+}
+
+// canonical extension of each supported mime type. stored files are named by
+// the server, so the extension never comes from the client
+var POST_FILE_EXTENSIONS = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"video/webm":      ".webm",
+	"video/mp4":       ".mp4",
+	"video/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/flac":      ".flac",
+	"audio/ogg":       ".opus",
+	"application/pdf": ".pdf",
+}
+
+// sniffs the mime type of file from its first bytes. the caller rewinds it
+func DetectPostFileMimeType(file multipart.File) (string, error) {
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	return SniffPostFileMimeType(buffer[:n]), nil
+}
+
+// like http.DetectContentType, which it falls back to, but also recognizes
+// AVIF, FLAC, MP3 without an ID3 tag and tells Ogg Opus from Ogg Theora
+func SniffPostFileMimeType(head []byte) string {
+	switch {
+	case len(head) >= 12 && string(head[4:8]) == "ftyp" && isAvifBrand(head):
+		return "image/avif"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		// the codec is named in the first packet, right after the page header
+		first := head[:min(len(head), 64)]
+		switch {
+		case bytes.Contains(first, []byte("OpusHead")):
+			return "audio/ogg"
+		case bytes.Contains(first, []byte("\x80theora")):
+			return "video/ogg"
+		}
+		return "application/ogg"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE6 == 0xE2:
+		// mpeg audio frame sync with layer III
+		return "audio/mpeg"
+	}
+
+	return http.DetectContentType(head)
+}
+
+// reports whether the ftyp box at the start of head lists an AVIF brand
+func isAvifBrand(head []byte) bool {
+	size := int(head[0])<<24 | int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	end := min(size, len(head))
+
+	// major brand, then compatible brands after the minor version
+	brands := [][]byte{head[8:12]}
+	for i := 16; i+4 <= end; i += 4 {
+		brands = append(brands, head[i:i+4])
+	}
+	for _, brand := range brands {
+		if string(brand) == "avif" || string(brand) == "avis" {
+			return true
+		}
+	}
+	return false
+}
+
+func DetectPostFileType(file multipart.File) (PostMediaType, error) {
+	fileType, err := DetectPostFileMimeType(file)
+	if err != nil {
+		return PostFileUnsupported, err
+	}
+	return PostFileMediaType(fileType), nil
+}
+
+// reports whether the upload in data is finished by a media job rather than
+// in the request, because it needs ffmpeg or pdftoppm. the webp decoder cannot
+// read animations either
+func NeedsMediaJob(mimeType string, data []byte) bool {
+	switch PostFileMediaType(mimeType) {
+	case PostFileVideo, PostFileAudio, PostFilePdf:
+		return true
+	}
+
+	switch mimeType {
+	case "image/avif":
+		return true
+	case "image/webp":
+		return IsAnimatedWebp(data)
+	case "image/gif":
+		// only ffmpeg can make the animated preview
+		return GetConfig().Media.AnimatedPreviews && IsAnimatedGif(data)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+const POSTER_ID_LEN = 8
+
+// how long the salt behind poster ids lives before it is regenerated
+const POSTER_ID_SALT_TTL = 7 * 24 * time.Hour
+
+// short per-thread id for a poster. the same ip gets a different id in every thread
+func PosterId(salt string, ipHash string, threadId int) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	fmt.Fprintf(mac, "%s:%d", ipHash, threadId)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:POSTER_ID_LEN]
+}
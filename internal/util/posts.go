@@ -1,49 +1,29 @@
 package util
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"image"
 	"io"
 	"log"
+	"mime"
 	"mime/multipart"
-	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
-	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/disintegration/imaging"
 )
 
-// 10 MB memory limit
-const FILE_MEM_LIMIT int64 = 10 << 20
-const MAX_REQUEST_BYTES int64 = FILE_MEM_LIMIT + (1 << 20)
-
-var (
-	POST_MEDIA_FULL_PATH  = "media/posts/full"
-	POST_MEDIA_THUMB_PATH = "media/posts/thumb"
-)
-
-const MAX_THREAD_COUNT = 50
-
-const MAX_BODY_LEN = 3000
-const MAX_SUBJECT_LEN = 50
-
-var SUPPORTED_IMAGE_MIME_TYPES = []string{"image/jpeg", "image/png", "image/gif"}
-var SUPPORTED_VIDEO_MIME_TYPES = []string{"video/webm", "video/mp4", "video/ogg"}
-
-type PostMediaType int64
-
-const (
-	PostFileImage PostMediaType = iota
-	PostFileVideo
-	PostFileUnsupported
-)
-
 var urlRx = regexp.MustCompile(`(?i)\bhttps?://[^\s<]+`)
 
 func EnrichPost(body string) string {
@@ -87,164 +67,398 @@ func EnrichPost(body string) string {
 	return b.String()
 }
 
-func DetectPostFileType(file multipart.File) (PostMediaType, error) {
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return PostFileUnsupported, err
+// saves file as baseName plus the extension of its sniffed type, after
+// running it through the upload processing stage, and returns its details.
+// images and their thumbnails are prepared in a temporary directory and then
+// handed to MEDIA_STORE. files needing external tools are only staged under
+// their pending key with an empty thumbnail name, ProcessPendingFile finishes
+// them. baseName is generated by the server and must not contain path
+// separators
+func SavePostFile(file multipart.File, baseName string, opts UploadOptions) (error, string, string, PostFileInfo) {
+	if baseName == "" || baseName != filepath.Base(baseName) {
+		return fmt.Errorf("invalid post file name %q", baseName), "", "", PostFileInfo{}
 	}
 
-	fileType := http.DetectContentType(buffer[:n])
+	mimeType, err := DetectPostFileMimeType(file)
+	if err != nil {
+		return err, "", "", PostFileInfo{}
+	}
+	file.Seek(0, io.SeekStart)
 
-	switch {
-	case slices.Contains(SUPPORTED_IMAGE_MIME_TYPES, fileType):
-		return PostFileImage, nil
-	case slices.Contains(SUPPORTED_VIDEO_MIME_TYPES, fileType):
-		return PostFileVideo, nil
-	default:
-		return PostFileUnsupported, nil
+	fileExt, ok := POST_FILE_EXTENSIONS[mimeType]
+	if !ok {
+		return fmt.Errorf("unsupported post file type %q", mimeType), "", "", PostFileInfo{}
 	}
-}
+	fileName := baseName + fileExt
 
-func SavePostFile(file multipart.File, fileName string) (error, string, string) {
-	mediaType, err := DetectPostFileType(file)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		return err, "", ""
+		log.Printf("io.ReadAll: %v", err)
+		return err, "", "", PostFileInfo{}
 	}
-	file.Seek(0, io.SeekStart)
 
-	fileExt := strings.ToLower(filepath.Ext(fileName))
+	// ffmpeg and pdftoppm run in the media job workers, not in the request
+	if NeedsMediaJob(mimeType, data) {
+		if err := MEDIA_STORE.Put(PostPendingKey(fileName), bytes.NewReader(data), mimeType); err != nil {
+			log.Printf("MEDIA_STORE.Put (pending): %v", err)
+			return err, "", "", PostFileInfo{}
+		}
+		return nil, fileName, "", PostFileInfo{MimeType: mimeType, Size: int64(len(data))}
+	}
 
-	// FULL
-	if err := os.MkdirAll(POST_MEDIA_FULL_PATH, 0755); err != nil {
-		log.Printf("MkdirAll (full): %v", err)
-		return err, "", ""
+	data, err = ProcessImageUpload(data, mimeType, opts)
+	if err != nil {
+		log.Printf("ProcessImageUpload: %v", err)
+		return err, "", "", PostFileInfo{}
 	}
 
-	dstPathFull := filepath.Join(POST_MEDIA_FULL_PATH, fileName)
-	dstFull, err := os.Create(dstPathFull)
+	workDir, err := os.MkdirTemp("", "comfychan-upload-*")
 	if err != nil {
-		log.Printf("os.Create (full): %v", err)
-		return err, "", ""
+		log.Printf("MkdirTemp: %v", err)
+		return err, "", "", PostFileInfo{}
 	}
-	defer dstFull.Close()
-	if _, err := io.Copy(dstFull, file); err != nil {
-		log.Printf("io.Copy (full): %v", err)
-		return err, "", ""
+	defer os.RemoveAll(workDir)
+
+	// FULL
+	dstPathFull := filepath.Join(workDir, fileName)
+	if err := os.WriteFile(dstPathFull, data, 0644); err != nil {
+		log.Printf("os.WriteFile (full): %v", err)
+		return err, "", "", PostFileInfo{}
 	}
 
-	if _, err := (file).Seek(0, 0); err != nil { // rewind file
-		log.Printf("seek file (thumb): %v", err)
-		return err, "", ""
+	info, err := ProbePostFile(context.Background(), dstPathFull)
+	if err != nil {
+		log.Printf("ProbePostFile: %v", err)
+		return err, "", "", PostFileInfo{}
 	}
 
 	// THUMBNAIL
-	dstPathThumb := filepath.Join(POST_MEDIA_THUMB_PATH, fileName)
-	if err := os.MkdirAll(POST_MEDIA_THUMB_PATH, 0755); err != nil {
-		log.Printf("MkdirAll (thumb): %v", err)
-		return err, "", ""
-	}
-
-	var thumbFileName string
-	if mediaType == PostFileVideo {
-		fileNameNoExt := strings.TrimSuffix(fileName, fileExt)
-		thumbFileName = fileNameNoExt + ".jpg"
-
-		inputPath := filepath.Join(POST_MEDIA_FULL_PATH, fileName)
-		outputPath := filepath.Join(POST_MEDIA_THUMB_PATH, thumbFileName)
-
-		cmd := exec.Command(
-			"ffmpeg",
-			"-i", inputPath,
-			"-ss", "00:00:01.000",
-			"-vframes", "1",
-			"-vf", "scale=300:-1",
-			outputPath,
-		)
-
-		if err := cmd.Run(); err != nil {
-			log.Printf("ffmpeg error: %v", err)
-			return err, "", ""
-		}
+	thumbDir := filepath.Join(workDir, "thumb")
+	if err := os.Mkdir(thumbDir, 0755); err != nil {
+		log.Printf("Mkdir (thumb): %v", err)
+		return err, "", "", PostFileInfo{}
+	}
+
+	thumbFileName := fileName
+	if mimeType == "image/webp" {
+		// imaging cannot encode webp
+		thumbFileName = baseName + ".png"
+	}
 
+	// files kept with their exif still need an upright thumbnail
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		log.Printf("imaging.Decode: %v", err)
+		return err, "", "", PostFileInfo{}
+	}
+
+	var thumb image.Image
+	if img.Bounds().Dx() > 300 {
+		thumb = imaging.Resize(img, 300, 0, imaging.Lanczos)
 	} else {
-		thumbFileName = fileName
+		thumb = img
+	}
 
-		img, _, err := image.Decode(file)
-		if err != nil {
-			log.Printf("image.Decode: %v", err)
-			return err, "", ""
+	if err = imaging.Save(thumb, filepath.Join(thumbDir, thumbFileName)); err != nil {
+		log.Printf("imaging.Save: %v", err)
+		return err, "", "", PostFileInfo{}
+	}
+
+	// STORE
+	if err := storePostFile(fileName, dstPathFull, mimeType, thumbFileName, filepath.Join(thumbDir, thumbFileName)); err != nil {
+		return err, "", "", PostFileInfo{}
+	}
+
+	return nil, fileName, thumbFileName, info
+}
+
+// puts a prepared file and its thumbnail in MEDIA_STORE. the file is removed
+// again if the thumbnail cannot be stored
+func storePostFile(fileName, filePath, mimeType, thumbFileName, thumbPath string) error {
+	if err := putMediaFile(PostMediaKey(fileName), filePath, mimeType); err != nil {
+		log.Printf("MEDIA_STORE.Put (full): %v", err)
+		return err
+	}
+
+	thumbMimeType := mime.TypeByExtension(filepath.Ext(thumbFileName))
+	if err := putMediaFile(PostThumbKey(thumbFileName), thumbPath, thumbMimeType); err != nil {
+		log.Printf("MEDIA_STORE.Put (thumb): %v", err)
+		MEDIA_STORE.Delete(PostMediaKey(fileName))
+		return err
+	}
+	return nil
+}
+
+func putMediaFile(key, filePath, contentType string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return MEDIA_STORE.Put(key, file, contentType)
+}
+
+// how long media stays after its last post is gone before it is deleted
+const RELEASED_MEDIA_GRACE = time.Hour
+
+// removes a saved post file, its thumbnail and its animated preview. missing
+// files are ignored
+func DeletePostFile(mediaPath, thumbPath, previewPath string) error {
+	if mediaPath != "" {
+		if err := MEDIA_STORE.Delete(PostMediaKey(mediaPath)); err != nil {
+			return err
+		}
+	}
+	// videos have no thumbnail until they are processed
+	if mediaPath != "" && thumbPath == "" {
+		if err := MEDIA_STORE.Delete(PostPendingKey(mediaPath)); err != nil {
+			return err
+		}
+	}
+	if thumbPath != "" {
+		if err := MEDIA_STORE.Delete(PostThumbKey(thumbPath)); err != nil {
+			return err
+		}
+	}
+	if previewPath != "" {
+		if err := MEDIA_STORE.Delete(PostPreviewKey(previewPath)); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+const MAX_ORIGINAL_NAME_LEN = 255
 
-		var thumb image.Image
-		if img.Bounds().Dx() > 300 {
-			thumb = imaging.Resize(img, 300, 0, imaging.Lanczos)
-		} else {
-			thumb = img
+// cleans a client supplied file name for display. only the base name is kept
+// and control characters are dropped
+func SanitizeOriginalName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
 		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "." || name == "/" {
+		return ""
+	}
 
-		if err = imaging.Save(thumb, dstPathThumb); err != nil {
-			log.Printf("imaging.Save: %v", err)
-			return err, "", ""
+	runes := []rune(name)
+	if len(runes) > MAX_ORIGINAL_NAME_LEN {
+		ext := []rune(path.Ext(name))
+		if len(ext) >= MAX_ORIGINAL_NAME_LEN {
+			ext = nil
 		}
+		runes = append(runes[:MAX_ORIGINAL_NAME_LEN-len(ext)], ext...)
+	}
+	return string(runes)
+}
+
+// shortens name to at most maxLen characters for display, keeping its extension
+func ShortenFileName(name string, maxLen int) string {
+	runes := []rune(name)
+	if len(runes) <= maxLen {
+		return name
 	}
 
-	return nil, fileName, thumbFileName
+	ext := []rune(path.Ext(name))
+	keep := maxLen - len(ext) - 1
+	if keep < 1 {
+		return string(runes[:maxLen-1]) + "…"
+	}
+	return string(runes[:keep]) + "…" + string(ext)
 }
 
+// details of a stored post file, computed once when it is saved
 type PostFileInfo struct {
-	Size    int64
-	Height  int
-	Width   int
-	IsVideo bool
+	MimeType string
+	Size     int64
+	Height   int
+	Width    int
+	Duration time.Duration // videos and audio only
 }
 
-func GetPostFileInfo(mediaPath string) PostFileInfo {
-	var result PostFileInfo
+func (info PostFileInfo) MediaType() PostMediaType {
+	return PostFileMediaType(info.MimeType)
+}
 
-	filePath := path.Join(POST_MEDIA_FULL_PATH, mediaPath)
+func (info PostFileInfo) IsVideo() bool {
+	return info.MediaType() == PostFileVideo
+}
 
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		log.Printf("Failed to get file info at %s: %v", mediaPath, err)
-		return PostFileInfo{}
-	}
+// reads the details of the saved file at filePath. dimensions of videos and
+// AVIF images and every duration come from ffprobe. pdfs only have a size
+func ProbePostFile(ctx context.Context, filePath string) (PostFileInfo, error) {
+	var result PostFileInfo
 
 	file, err := os.Open(filePath)
 	if err != nil {
-		log.Printf("Failed to open file at %s: %v", mediaPath, err)
-		return PostFileInfo{}
+		return PostFileInfo{}, err
 	}
 	defer file.Close()
 
-	// read file type
-	mediaType, _ := DetectPostFileType(file)
-	file.Seek(0, io.SeekStart)
-
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return PostFileInfo{}, err
+	}
 	result.Size = fileInfo.Size()
 
-	if mediaType != PostFileVideo {
-		cfg, _, err := image.DecodeConfig(file)
-		if err != nil {
-			log.Printf("Failed to decode image at %s: %v", mediaPath, err)
-			return PostFileInfo{}
-		}
+	result.MimeType, err = DetectPostFileMimeType(file)
+	if err != nil {
+		return PostFileInfo{}, err
+	}
+	file.Seek(0, io.SeekStart)
 
+	switch result.MediaType() {
+	case PostFileVideo:
+		result.Width, result.Height, result.Duration, err = probeMedia(ctx, filePath)
+	case PostFileAudio:
+		// the video stream of an audio file is its cover art
+		_, _, result.Duration, err = probeMedia(ctx, filePath)
+	case PostFileImage:
+		if result.MimeType == "image/avif" {
+			result.Width, result.Height, _, err = probeMedia(ctx, filePath)
+			break
+		}
+		var cfg image.Config
+		cfg, _, err = image.DecodeConfig(file)
 		result.Width = cfg.Width
 		result.Height = cfg.Height
-		result.IsVideo = false
+	}
+	if err != nil {
+		return PostFileInfo{}, err
+	}
 
-	} else {
-		result.IsVideo = true
+	return result, nil
+}
+
+func probeMedia(ctx context.Context, filePath string) (int, int, time.Duration, error) {
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		filePath,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("ffprobe: %w", err)
 	}
 
-	return result
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return 0, 0, 0, fmt.Errorf("ffprobe: %w", err)
+	}
+
+	var width, height int
+	if len(probe.Streams) > 0 {
+		width = probe.Streams[0].Width
+		height = probe.Streams[0].Height
+	}
+
+	// missing for some streamed webms
+	var duration time.Duration
+	if seconds, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	return width, height, duration, nil
+}
+
+// reads the details of a file already in MEDIA_STORE, for files saved before
+// they were recorded. the file is copied to a temporary one for ffprobe
+func GetPostFileInfo(mediaPath string) (PostFileInfo, error) {
+	object, err := MEDIA_STORE.Open(PostMediaKey(mediaPath))
+	if err != nil {
+		return PostFileInfo{}, err
+	}
+	defer object.Close()
+
+	tmp, err := os.CreateTemp("", "comfychan-probe-*"+filepath.Ext(mediaPath))
+	if err != nil {
+		return PostFileInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, object); err != nil {
+		return PostFileInfo{}, err
+	}
+
+	return ProbePostFile(context.Background(), tmp.Name())
 }
 
 func FormatPostFileInfo(fileInfo PostFileInfo) string {
+	// not recorded yet, see GetPostFileInfo
+	if fileInfo.MimeType == "" {
+		return ""
+	}
+
 	humanSize := FormatBytes(fileInfo.Size)
-	if fileInfo.IsVideo {
-		return fmt.Sprintf("(%s)", humanSize)
+	switch fileInfo.MediaType() {
+	case PostFileVideo:
+		if fileInfo.Width == 0 || fileInfo.Height == 0 {
+			return fmt.Sprintf("(%s, %s)", humanSize, FormatDuration(fileInfo.Duration))
+		}
+		return fmt.Sprintf("(%s, %dx%d, %s)", humanSize, fileInfo.Width, fileInfo.Height, FormatDuration(fileInfo.Duration))
+	case PostFileAudio:
+		return fmt.Sprintf("(%s, %s)", humanSize, FormatDuration(fileInfo.Duration))
+	case PostFilePdf:
+		return fmt.Sprintf("(%s, PDF)", humanSize)
+	default:
+		return fmt.Sprintf("(%s, %dx%d)", humanSize, fileInfo.Width, fileInfo.Height)
+	}
+}
+
+// formats d as m:ss, or h:mm:ss for an hour or more
+func FormatDuration(d time.Duration) string {
+	seconds := int64(d.Round(time.Second) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
 	}
-	return fmt.Sprintf("(%s, %dx%d)", humanSize, fileInfo.Width, fileInfo.Height)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+type PostOptions struct {
+	Sage   bool // reply without bumping
+	NoNoko bool // return to the board after posting instead of the thread
+}
+
+const MAX_OPTIONS_LEN = 50
+
+const MAX_REPORT_COMMENT_LEN = 200
+
+// parses the options field, e.g. "sage" or "sage nonoko". noko (stay in the
+// thread) is the default, unknown options are ignored
+func ParsePostOptions(input string) PostOptions {
+	var result PostOptions
+	fields := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	for _, field := range fields {
+		switch field {
+		case "sage":
+			result.Sage = true
+		case "noko":
+			result.NoNoko = false
+		case "nonoko":
+			result.NoNoko = true
+		}
+	}
+	return result
 }
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"slices"
+)
+
+type StaffRole string
+
+const (
+	RoleOwner   StaffRole = "owner"
+	RoleAdmin   StaffRole = "admin"
+	RoleMod     StaffRole = "mod" // a global moderator
+	RoleJanitor StaffRole = "janitor"
+)
+
+// every role, from the most to the least privileged
+var STAFF_ROLES = []StaffRole{RoleOwner, RoleAdmin, RoleMod, RoleJanitor}
+
+var staffRoleLabels = map[StaffRole]string{
+	RoleOwner:   "Owner",
+	RoleAdmin:   "Admin",
+	RoleMod:     "Global mod",
+	RoleJanitor: "Board janitor",
+}
+
+type Permission string
+
+const (
+	PermDelete       Permission = "delete"   // delete posts and threads
+	PermBan          Permission = "ban"      // ban ips and files
+	PermPinLock      Permission = "pin_lock" // pin and lock threads, and reply to locked ones
+	PermViewModLog   Permission = "view_mod_log"
+	PermManageBoards Permission = "manage_boards"
+	PermManageStaff  Permission = "manage_staff"
+)
+
+var rolePermissions = map[StaffRole][]Permission{
+	RoleOwner:   {PermDelete, PermBan, PermPinLock, PermViewModLog, PermManageBoards, PermManageStaff},
+	RoleAdmin:   {PermDelete, PermBan, PermPinLock, PermViewModLog, PermManageBoards, PermManageStaff},
+	RoleMod:     {PermDelete, PermBan, PermPinLock, PermViewModLog},
+	RoleJanitor: {PermDelete},
+}
+
+func ParseStaffRole(s string) (StaffRole, error) {
+	role := StaffRole(s)
+	if !slices.Contains(STAFF_ROLES, role) {
+		return "", fmt.Errorf("Unknown role %q", s)
+	}
+	return role, nil
+}
+
+func (r StaffRole) Label() string {
+	if label, ok := staffRoleLabels[r]; ok {
+		return label
+	}
+	return string(r)
+}
+
+// whether the role grants p. board scoped roles only have it on their boards
+func (r StaffRole) Can(p Permission) bool {
+	return slices.Contains(rolePermissions[r], p)
+}
+
+// janitors only moderate the boards they are assigned
+func (r StaffRole) IsBoardScoped() bool {
+	return r == RoleJanitor
+}
+
+// whether staff with the role may invite, edit or remove staff with target.
+// only owners manage their peers, so admins can't take over the site
+func (r StaffRole) CanManage(target StaffRole) bool {
+	if !r.Can(PermManageStaff) {
+		return false
+	}
+	return r == RoleOwner || slices.Index(STAFF_ROLES, r) < slices.Index(STAFF_ROLES, target)
+}
@@ -0,0 +1,38 @@
+package util
+
+import "testing"
+
+func TestStaffRoleCanManage(t *testing.T) {
+	tests := []struct {
+		role   StaffRole
+		target StaffRole
+		want   bool
+	}{
+		{RoleOwner, RoleOwner, true},
+		{RoleOwner, RoleAdmin, true},
+		{RoleOwner, RoleMod, true},
+		{RoleOwner, RoleJanitor, true},
+
+		{RoleAdmin, RoleOwner, false},
+		{RoleAdmin, RoleAdmin, false},
+		{RoleAdmin, RoleMod, true},
+		{RoleAdmin, RoleJanitor, true},
+
+		{RoleMod, RoleOwner, false},
+		{RoleMod, RoleAdmin, false},
+		{RoleMod, RoleMod, false},
+		{RoleMod, RoleJanitor, false},
+
+		{RoleJanitor, RoleOwner, false},
+		{RoleJanitor, RoleAdmin, false},
+		{RoleJanitor, RoleMod, false},
+		{RoleJanitor, RoleJanitor, false},
+
+		{StaffRole("unknown"), RoleJanitor, false},
+	}
+	for _, tt := range tests {
+		if got := tt.role.CanManage(tt.target); got != tt.want {
+			t.Errorf("%s.CanManage(%s) = %v, want %v", tt.role, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package util
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// stores media in a bucket of an S3 compatible service (AWS, MinIO, R2, ...).
+// requests use path style addressing and AWS signature version 4
+type S3MediaStore struct {
+	Endpoint  string // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// base url browsers load objects from. defaults to Endpoint/Bucket, which
+	// requires the bucket to allow public reads
+	PublicURL string
+	Client    *http.Client
+}
+
+func (s *S3MediaStore) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *S3MediaStore) objectURL(key string) string {
+	return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+func (s *S3MediaStore) Put(key string, r io.Reader, contentType string) error {
+	// the payload hash is part of the signature, so the body is buffered.
+	// uploads are bounded by the max_file_size limit
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := s.do(req, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s3Error(res, key)
+	}
+	return nil
+}
+
+func (s *S3MediaStore) Open(key string) (MediaObject, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return MediaObject{}, err
+	}
+
+	res, err := s.do(req, nil)
+	if err != nil {
+		return MediaObject{}, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
+		return MediaObject{}, s3Error(res, key)
+	}
+	return MediaObject{ReadCloser: res.Body, Size: res.ContentLength}, nil
+}
+
+func (s *S3MediaStore) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s3Error(res, key)
+	}
+}
+
+func (s *S3MediaStore) URL(key string) string {
+	if s.PublicURL == "" {
+		return s.objectURL(key)
+	}
+	return strings.TrimSuffix(s.PublicURL, "/") + "/" + strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+func s3Error(res *http.Response, key string) error {
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	err := fmt.Errorf("s3 %s %s: %s: %s", res.Request.Method, key, res.Status, bytes.TrimSpace(msg))
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return err
+}
+
+func (s *S3MediaStore) do(req *http.Request, body []byte) (*http.Response, error) {
+	signS3Request(req, body, s.Region, s.AccessKey, s.SecretKey, time.Now())
+	return s.client().Do(req)
+}
+
+// signs req with AWS signature version 4 for the s3 service
+func signS3Request(req *http.Request, body []byte, region, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+	payloadHashHex := hex.EncodeToString(payloadHash[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHashHex)
+
+	host := req.URL.Host
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + host + "\n" +
+		"x-amz-content-sha256:" + payloadHashHex + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHashHex,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	signingKey := hmacSha256([]byte("AWS4"+secretKey), date)
+	signingKey = hmacSha256(signingKey, region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uri encodes every path segment as sigv4 expects, keeping the slashes
+func s3EscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	// url.Values.Encode sorts by key but escapes spaces as "+"
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// percent encodes everything but the unreserved characters of RFC 3986
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+const DEFAULT_AUTHOR = "Anonymous"
+
+const MAX_NAME_LEN = 50
+
+const TRIPCODE_LEN = 10
+
+// splits a name field into its display name and tripcode.
+//
+//	name#password  -> classic tripcode "!xxxxxxxxxx", identical on every server
+//	name##secret   -> secure tripcode "!!xxxxxxxxxx", salted with serverSecret
+//
+// an empty name falls back to DEFAULT_AUTHOR
+func ParseAuthor(input string, serverSecret string) (string, string) {
+	name, password, hasTrip := strings.Cut(input, "#")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = DEFAULT_AUTHOR
+	}
+
+	if !hasTrip || password == "" {
+		return name, ""
+	}
+
+	if secret, isSecure := strings.CutPrefix(password, "#"); isSecure {
+		if secret == "" {
+			return name, ""
+		}
+		return name, "!!" + SecureTripcode(secret, serverSecret)
+	}
+
+	return name, "!" + ClassicTripcode(password)
+}
+
+func ClassicTripcode(password string) string {
+	checksum := sha256.Sum256([]byte(password))
+	return encodeTripcode(checksum[:])
+}
+
+func SecureTripcode(secret string, serverSecret string) string {
+	mac := hmac.New(sha256.New, []byte(serverSecret))
+	mac.Write([]byte(secret))
+	return encodeTripcode(mac.Sum(nil))
+}
+
+func encodeTripcode(sum []byte) string {
+	return base64.RawURLEncoding.EncodeToString(sum)[:TRIPCODE_LEN]
+}
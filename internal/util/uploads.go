@@ -0,0 +1,596 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+)
+
+const REENCODE_JPEG_QUALITY = 90
+
+// clips shorter than this are thumbnailed from their start instead of a frame
+// further in, which may not exist
+const MIN_THUMB_SEEK_DURATION = 2 * time.Second
+
+const PREVIEW_DURATION = 3 * time.Second
+const PREVIEW_FPS = 10
+
+type UploadOptions struct {
+	StripMetadata bool
+}
+
+// prepares an uploaded image for storage. oversized jpegs and pngs are
+// downscaled, and with opts.StripMetadata EXIF, XMP, IPTC and text chunks are
+// removed. jpegs are only re-encoded when they must be resized or rotated,
+// otherwise metadata is cut out losslessly
+func ProcessImageUpload(data []byte, mimeType string, opts UploadOptions) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	maxDimension := GetConfig().Media.MaxImageDimension
+	oversized := maxDimension > 0 && (cfg.Width > maxDimension || cfg.Height > maxDimension)
+
+	// stripping exif also drops the orientation, so it has to be applied to
+	// the pixels instead
+	rotated := opts.StripMetadata && mimeType == "image/jpeg" && JpegOrientation(data) > 1
+
+	// animated gifs would lose every frame but the first, and webp cannot be
+	// encoded
+	if (oversized || rotated) && (mimeType == "image/jpeg" || mimeType == "image/png") {
+		return reencodeImage(data, mimeType, maxDimension)
+	}
+
+	if !opts.StripMetadata {
+		return data, nil
+	}
+
+	switch mimeType {
+	case "image/jpeg":
+		return stripJpegMetadata(data)
+	case "image/png":
+		return stripPngMetadata(data)
+	case "image/webp":
+		return stripWebpMetadata(data)
+	default:
+		return data, nil
+	}
+}
+
+func reencodeImage(data []byte, mimeType string, maxDimension int) ([]byte, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+
+	if maxDimension > 0 {
+		img = imaging.Fit(img, maxDimension, maxDimension, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	switch mimeType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: REENCODE_JPEG_QUALITY})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// removes container metadata from the video or audio file at filePath in
+// place. every stream is copied, not re-encoded, so cover art and extra audio
+// or subtitle tracks are kept
+func StripMediaMetadata(ctx context.Context, filePath string) error {
+	ext := filepath.Ext(filePath)
+	// keep the extension so ffmpeg picks the same container
+	tmpPath := strings.TrimSuffix(filePath, ext) + ".tmp" + ext
+
+	err := runFfmpeg(ctx,
+		"-y",
+		"-i", filePath,
+		"-map", "0",
+		"-map_metadata", "-1",
+		"-c", "copy",
+		tmpPath,
+	)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, filePath)
+}
+
+// strips, probes and thumbnails a file staged by SavePostFile, then moves it
+// from its pending key to the full one. when an earlier job already moved the
+// same upload, the stored file is processed again instead. returns the
+// thumbnail and preview names and the file's details. the preview name is
+// empty when previews are disabled or could not be made. if neither file
+// exists the error wraps fs.ErrNotExist
+func ProcessPendingFile(ctx context.Context, fileName string, opts UploadOptions) (string, string, PostFileInfo, error) {
+	object, err := MEDIA_STORE.Open(PostPendingKey(fileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		object, err = MEDIA_STORE.Open(PostMediaKey(fileName))
+	}
+	if err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+	defer object.Close()
+
+	workDir, err := os.MkdirTemp("", "comfychan-media-*")
+	if err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+	defer os.RemoveAll(workDir)
+
+	// FULL
+	dstPathFull := filepath.Join(workDir, fileName)
+	dst, err := os.Create(dstPathFull)
+	if err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+	if _, err := io.Copy(dst, object); err != nil {
+		dst.Close()
+		return "", "", PostFileInfo{}, err
+	}
+	if err := dst.Close(); err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+
+	mimeType, err := detectFileMimeType(dstPathFull)
+	if err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+	mediaType := PostFileMediaType(mimeType)
+
+	// AVIF images and pdfs are kept as uploaded
+	if opts.StripMetadata && (mediaType == PostFileVideo || mediaType == PostFileAudio) {
+		if err := StripMediaMetadata(ctx, dstPathFull); err != nil {
+			return "", "", PostFileInfo{}, err
+		}
+	}
+
+	info, err := ProbePostFile(ctx, dstPathFull)
+	if err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+
+	// THUMBNAIL
+	thumbDir := filepath.Join(workDir, "thumb")
+	if err := os.Mkdir(thumbDir, 0755); err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+
+	baseName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	thumbFileName, err := generateThumbnail(ctx, dstPathFull, mediaType, info.Duration, thumbDir, baseName)
+	if err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+
+	// STORE
+	if err := storePostFile(fileName, dstPathFull, info.MimeType, thumbFileName, filepath.Join(thumbDir, thumbFileName)); err != nil {
+		return "", "", PostFileInfo{}, err
+	}
+
+	if err := MEDIA_STORE.Delete(PostPendingKey(fileName)); err != nil {
+		log.Printf("MEDIA_STORE.Delete (pending): %v", err)
+	}
+
+	// PREVIEW
+	// optional, so failing to make one keeps the file without it
+	var previewFileName string
+	if GetConfig().Media.AnimatedPreviews && (mediaType == PostFileVideo || mimeType == "image/gif") {
+		previewFileName = baseName + ".webp"
+		previewPath := filepath.Join(thumbDir, previewFileName)
+		if err := generatePreview(ctx, dstPathFull, info.Duration, previewPath); err != nil {
+			log.Printf("generatePreview (%s): %v", fileName, err)
+			previewFileName = ""
+		} else if err := putMediaFile(PostPreviewKey(previewFileName), previewPath, "image/webp"); err != nil {
+			log.Printf("MEDIA_STORE.Put (preview): %v", err)
+			previewFileName = ""
+		}
+	}
+
+	return thumbFileName, previewFileName, info, nil
+}
+
+func detectFileMimeType(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return DetectPostFileMimeType(file)
+}
+
+// writes a thumbnail of the file at filePath to dir and returns its name.
+// the frame of videos is picked by their duration
+func generateThumbnail(ctx context.Context, filePath string, mediaType PostMediaType, duration time.Duration, dir, baseName string) (string, error) {
+	switch mediaType {
+	case PostFileVideo:
+		thumbFileName := baseName + ".jpg"
+		thumbPath := filepath.Join(dir, thumbFileName)
+		return thumbFileName, withSeekFallback(ctx, duration, func(seek time.Duration) error {
+			// the thumbnail filter picks the most representative of the next
+			// frames, skipping fades and black frames
+			return runFfmpegOutput(ctx, thumbPath,
+				"-ss", ffmpegTime(seek),
+				"-i", filePath,
+				"-vf", "thumbnail=50,scale='min(300,iw)':-1",
+				"-frames:v", "1",
+			)
+		})
+
+	case PostFileAudio:
+		// the embedded cover art, or a waveform when there is none
+		thumbFileName := baseName + ".jpg"
+		err := runFfmpeg(ctx,
+			"-i", filePath,
+			"-an",
+			"-vframes", "1",
+			"-vf", "scale=300:-1",
+			filepath.Join(dir, thumbFileName),
+		)
+		if err == nil || ctx.Err() != nil {
+			return thumbFileName, err
+		}
+
+		thumbFileName = baseName + ".png"
+		return thumbFileName, runFfmpeg(ctx,
+			"-i", filePath,
+			"-filter_complex", "showwavespic=s=300x80:colors=#34345c",
+			"-frames:v", "1",
+			filepath.Join(dir, thumbFileName),
+		)
+
+	case PostFilePdf:
+		// pdftoppm appends the extension to the output prefix
+		cmd := exec.CommandContext(
+			ctx,
+			"pdftoppm",
+			"-f", "1",
+			"-l", "1",
+			"-singlefile",
+			"-scale-to", "300",
+			"-jpeg",
+			filePath,
+			filepath.Join(dir, baseName),
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("pdftoppm: %w: %s", err, out)
+		}
+		return baseName + ".jpg", nil
+
+	default:
+		// AVIF and animated webp images. png keeps their transparency
+		thumbFileName := baseName + ".png"
+		return thumbFileName, runFfmpeg(ctx,
+			"-i", filePath,
+			"-vframes", "1",
+			"-vf", "scale='min(300,iw)':-1",
+			filepath.Join(dir, thumbFileName),
+		)
+	}
+}
+
+// writes a short looping animated webp of the video or gif at filePath to
+// outPath, starting where its thumbnail was taken
+func generatePreview(ctx context.Context, filePath string, duration time.Duration, outPath string) error {
+	return withSeekFallback(ctx, duration, func(seek time.Duration) error {
+		return runFfmpegOutput(ctx, outPath,
+			"-ss", ffmpegTime(seek),
+			"-t", ffmpegTime(PREVIEW_DURATION),
+			"-i", filePath,
+			"-an",
+			"-vf", fmt.Sprintf("fps=%d,scale='min(300,iw)':-1", PREVIEW_FPS),
+			"-loop", "0",
+			"-c:v", "libwebp",
+			"-quality", "60",
+		)
+	})
+}
+
+// a tenth into the clip, where intros and fades are usually over, or the
+// start of clips too short to seek in
+func thumbnailSeek(duration time.Duration) time.Duration {
+	if duration < MIN_THUMB_SEEK_DURATION {
+		return 0
+	}
+	return min(duration/10, 10*time.Second)
+}
+
+// runs extract at thumbnailSeek(duration), and again from the start if that
+// fails. durations come from container headers, which can be wrong
+func withSeekFallback(ctx context.Context, duration time.Duration, extract func(seek time.Duration) error) error {
+	seek := thumbnailSeek(duration)
+	err := extract(seek)
+	if err != nil && seek > 0 && ctx.Err() == nil {
+		err = extract(0)
+	}
+	return err
+}
+
+func ffmpegTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// runs ffmpeg with args writing to outPath, which it must create. ffmpeg
+// exits successfully without writing anything when seeking past the end
+func runFfmpegOutput(ctx context.Context, outPath string, args ...string) error {
+	if err := runFfmpeg(ctx, append(append([]string{"-y"}, args...), outPath)...); err != nil {
+		return err
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return fmt.Errorf("ffmpeg wrote no output: %w", err)
+	}
+	return nil
+}
+
+func runFfmpeg(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, out)
+	}
+	return nil
+}
+
+// jpeg segments kept when stripping: JFIF (APP0), ICC profiles (APP2) and
+// Adobe color transforms (APP14). every other APPn and comments are dropped
+var keptJpegSegments = map[byte]bool{0xE0: true, 0xE2: true, 0xEE: true}
+
+func stripJpegMetadata(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a jpeg")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errors.New("malformed jpeg segment")
+		}
+		marker := data[i+1]
+
+		// start of scan, the rest is image data
+		if marker == 0xDA {
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("malformed jpeg segment length")
+		}
+
+		isMetadata := (marker >= 0xE0 && marker <= 0xEF && !keptJpegSegments[marker]) || marker == 0xFE
+		if !isMetadata {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	return nil, errors.New("jpeg has no image data")
+}
+
+var strippedPngChunks = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+func stripPngMetadata(data []byte) ([]byte, error) {
+	const signatureLen = 8
+	if len(data) < signatureLen || !bytes.Equal(data[:signatureLen], []byte("\x89PNG\r\n\x1a\n")) {
+		return nil, errors.New("not a png")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:signatureLen])
+
+	i := signatureLen
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		// length, type, data and crc
+		end := i + 12 + length
+		if end > len(data) {
+			return nil, errors.New("malformed png chunk")
+		}
+
+		if !strippedPngChunks[chunkType] {
+			out.Write(data[i:end])
+		}
+		i = end
+
+		if chunkType == "IEND" {
+			return out.Bytes(), nil
+		}
+	}
+
+	return nil, errors.New("png has no end chunk")
+}
+
+const (
+	webpFlagAnimation = 0x02
+	webpFlagXmp       = 0x04
+	webpFlagExif      = 0x08
+)
+
+// drops the EXIF and XMP chunks of a webp and clears their VP8X flags
+func stripWebpMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a webp")
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+
+	i := 12
+	for i+8 <= len(data) {
+		chunkType := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		// chunks are padded to an even length
+		end := i + 8 + length + length%2
+		if end > len(data) {
+			return nil, errors.New("malformed webp chunk")
+		}
+
+		switch chunkType {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := bytes.Clone(data[i:end])
+			if len(chunk) > 8 {
+				chunk[8] &^= webpFlagExif | webpFlagXmp
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+	return result, nil
+}
+
+// reports whether data is a webp with more than one frame
+func IsAnimatedWebp(data []byte) bool {
+	// the VP8X chunk always comes first when present
+	if len(data) < 21 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" ||
+		string(data[12:16]) != "VP8X" {
+		return false
+	}
+	return data[20]&webpFlagAnimation != 0
+}
+
+// reports whether data is a gif with more than one frame
+func IsAnimatedGif(data []byte) bool {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF8")) {
+		return false
+	}
+
+	// header, logical screen descriptor and global color table
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21:
+			// extension introducer and label, then data sub-blocks
+			i = skipGifSubBlocks(data, i+2)
+		case 0x2C:
+			// image descriptor, local color table, lzw code size and image data
+			if i+10 > len(data) {
+				return false
+			}
+			frames++
+			if frames > 1 {
+				return true
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+			i = skipGifSubBlocks(data, i+1)
+		default:
+			// trailer
+			return false
+		}
+	}
+	return false
+}
+
+// returns the index after the sub-blocks starting at i and their terminator
+func skipGifSubBlocks(data []byte, i int) int {
+	for i < len(data) && data[i] != 0 {
+		i += int(data[i]) + 1
+	}
+	return i + 1
+}
+
+// returns the EXIF orientation (1-8) of a jpeg, or 0 when it has none
+func JpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA {
+			return 0
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return 0
+		}
+
+		segment := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i = end
+	}
+	return 0
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for n := 0; n < entries; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation < 1 || orientation > 8 {
+				return 0
+			}
+			return orientation
+		}
+	}
+	return 0
+}
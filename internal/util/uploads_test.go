@@ -0,0 +1,360 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+type tiffEntry struct {
+	tag, typ uint16
+	value    uint32
+}
+
+const (
+	tiffShort = 3
+	tiffLong  = 4
+
+	tagOrientation = 0x0112
+	tagGpsIfd      = 0x8825
+	tagGpsLatRef   = 0x0001
+)
+
+// a TIFF header and one IFD holding entries, followed by extra. SHORT values
+// are stored in the first two bytes of the value field
+func exifTiff(order binary.ByteOrder, entries []tiffEntry, extra []byte) []byte {
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+
+	binary.Write(&buf, order, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, order, e.tag)
+		binary.Write(&buf, order, e.typ)
+		binary.Write(&buf, order, uint32(1))
+		if e.typ == tiffShort {
+			binary.Write(&buf, order, uint16(e.value))
+			binary.Write(&buf, order, uint16(0))
+		} else {
+			binary.Write(&buf, order, e.value)
+		}
+	}
+	// no next IFD
+	binary.Write(&buf, order, uint32(0))
+	buf.Write(extra)
+	return buf.Bytes()
+}
+
+// an EXIF TIFF with a GPS IFD before the orientation entry, like photos from
+// a phone
+func gpsExifTiff(order binary.ByteOrder, orientation uint16) []byte {
+	// header, entry count, two entries and the next IFD offset
+	gpsOffset := uint32(8 + 2 + 2*12 + 4)
+
+	var gps bytes.Buffer
+	binary.Write(&gps, order, uint16(1))
+	binary.Write(&gps, order, uint16(tagGpsLatRef))
+	binary.Write(&gps, order, uint16(2)) // ASCII
+	binary.Write(&gps, order, uint32(2))
+	gps.WriteString("N\x00\x00\x00")
+	binary.Write(&gps, order, uint32(0))
+
+	return exifTiff(order, []tiffEntry{
+		{tagGpsIfd, tiffLong, gpsOffset},
+		{tagOrientation, tiffShort, uint32(orientation)},
+	}, gps.Bytes())
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return jpegSegment(0xE1, append([]byte("Exif\x00\x00"), tiff...))
+}
+
+func testJpeg(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// the jpeg with segments inserted right after its start of image marker
+func withJpegSegments(data []byte, segments ...[]byte) []byte {
+	out := bytes.Clone(data[:2])
+	for _, segment := range segments {
+		out = append(out, segment...)
+	}
+	return append(out, data[2:]...)
+}
+
+func TestStripJpegMetadata(t *testing.T) {
+	plain := testJpeg(t)
+	icc := jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"no metadata", plain, plain, false},
+		{
+			"exif with gps, little endian",
+			withJpegSegments(plain, exifSegment(gpsExifTiff(binary.LittleEndian, 6))),
+			plain, false,
+		},
+		{
+			"exif with gps, big endian",
+			withJpegSegments(plain, exifSegment(gpsExifTiff(binary.BigEndian, 6))),
+			plain, false,
+		},
+		{
+			"xmp and comment",
+			withJpegSegments(plain,
+				jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>")),
+				jpegSegment(0xFE, []byte("taken at home"))),
+			plain, false,
+		},
+		{
+			"icc profile is kept",
+			withJpegSegments(plain, icc, exifSegment(gpsExifTiff(binary.LittleEndian, 1))),
+			withJpegSegments(plain, icc), false,
+		},
+		{"not a jpeg", []byte("GIF89a"), nil, true},
+		{"empty", nil, nil, true},
+		{"truncated segment", withJpegSegments(plain, exifSegment(gpsExifTiff(binary.LittleEndian, 6)))[:20], nil, true},
+		{"segment length too short", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xDA}, nil, true},
+		{"no start of scan", withJpegSegments([]byte{0xFF, 0xD8}, icc), nil, true},
+		{"garbage between segments", []byte{0xFF, 0xD8, 0x00, 0xE1, 0x00, 0x02, 0xFF, 0xDA}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripJpegMetadata(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stripJpegMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripJpegMetadata() = % x, want % x", got, tt.want)
+			}
+			if got != nil {
+				if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+					t.Errorf("stripped jpeg does not decode: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestJpegOrientation(t *testing.T) {
+	plain := testJpeg(t)
+	jfif := jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", plain, 0},
+		{"little endian", withJpegSegments(plain, exifSegment(gpsExifTiff(binary.LittleEndian, 6))), 6},
+		{"big endian", withJpegSegments(plain, exifSegment(gpsExifTiff(binary.BigEndian, 3))), 3},
+		{"after jfif", withJpegSegments(plain, jfif, exifSegment(gpsExifTiff(binary.BigEndian, 8))), 8},
+		{"xmp app1", withJpegSegments(plain, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00"))), 0},
+		{"exif after start of scan", append(bytes.Clone(plain), exifSegment(gpsExifTiff(binary.LittleEndian, 6))...), 0},
+		{"truncated segment", withJpegSegments(plain, exifSegment(gpsExifTiff(binary.LittleEndian, 6)))[:30], 0},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 0},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JpegOrientation(tt.data); got != tt.want {
+				t.Errorf("JpegOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExifOrientation(t *testing.T) {
+	orientation := func(order binary.ByteOrder, value uint32) []byte {
+		return exifTiff(order, []tiffEntry{{tagOrientation, tiffShort, value}}, nil)
+	}
+	badOffset := orientation(binary.LittleEndian, 6)
+	binary.LittleEndian.PutUint32(badOffset[4:8], 1000)
+	tooManyEntries := exifTiff(binary.BigEndian, []tiffEntry{{tagGpsIfd, tiffLong, 0}}, nil)
+	binary.BigEndian.PutUint16(tooManyEntries[8:10], 2)
+
+	tests := []struct {
+		name string
+		tiff []byte
+		want int
+	}{
+		{"little endian", orientation(binary.LittleEndian, 6), 6},
+		{"big endian", orientation(binary.BigEndian, 6), 6},
+		{"gps first, little endian", gpsExifTiff(binary.LittleEndian, 5), 5},
+		{"gps first, big endian", gpsExifTiff(binary.BigEndian, 5), 5},
+		{"upright", orientation(binary.LittleEndian, 1), 1},
+		{"zero", orientation(binary.LittleEndian, 0), 0},
+		{"out of range", orientation(binary.BigEndian, 9), 0},
+		{"no orientation", exifTiff(binary.LittleEndian, []tiffEntry{{tagGpsIfd, tiffLong, 0}}, nil), 0},
+		{"unknown byte order", append([]byte("XX"), orientation(binary.LittleEndian, 6)[2:]...), 0},
+		{"ifd offset out of range", badOffset, 0},
+		{"entry count past the end", tooManyEntries, 0},
+		{"truncated header", []byte("II*\x00"), 0},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exifOrientation(tt.tiff); got != tt.want {
+				t.Errorf("exifOrientation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// the png with chunks inserted right after its IHDR chunk
+func withPngChunks(data []byte, chunks ...[]byte) []byte {
+	// signature and the 13 byte IHDR chunk
+	const ihdrEnd = 8 + 12 + 13
+	out := bytes.Clone(data[:ihdrEnd])
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+func TestStripPngMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+	gamma := pngChunk("gAMA", []byte{0, 0, 0xB1, 0x8F})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"no metadata", plain, plain, false},
+		{
+			"text chunks",
+			withPngChunks(plain,
+				pngChunk("tEXt", []byte("Author\x00someone")),
+				pngChunk("iTXt", []byte("Comment\x00\x00\x00\x00\x00hello")),
+				pngChunk("tIME", []byte{0x07, 0xE8, 1, 2, 3, 4, 5})),
+			plain, false,
+		},
+		{
+			"exif little endian",
+			withPngChunks(plain, pngChunk("eXIf", gpsExifTiff(binary.LittleEndian, 6))),
+			plain, false,
+		},
+		{
+			"exif big endian",
+			withPngChunks(plain, pngChunk("eXIf", gpsExifTiff(binary.BigEndian, 6))),
+			plain, false,
+		},
+		{
+			"other chunks are kept",
+			withPngChunks(plain, gamma, pngChunk("tEXt", []byte("Software\x00paint"))),
+			withPngChunks(plain, gamma), false,
+		},
+		{"not a png", []byte("RIFF"), nil, true},
+		{"truncated chunk", withPngChunks(plain, pngChunk("tEXt", []byte("Author\x00someone")))[:40], nil, true},
+		{"no end chunk", plain[:len(plain)-12], nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripPngMetadata(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stripPngMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripPngMetadata() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+func webpChunk(chunkType string, data []byte) []byte {
+	chunk := append([]byte(chunkType), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func testWebp(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	return append(append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...), body...)
+}
+
+func vp8x(flags byte) []byte {
+	// flags, reserved bytes and a 1x1 canvas
+	return webpChunk("VP8X", []byte{flags, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+}
+
+func TestStripWebpMetadata(t *testing.T) {
+	// odd length, so the chunk is padded
+	bitstream := webpChunk("VP8L", []byte{0x2F, 0, 0, 0, 0})
+	exif := webpChunk("EXIF", gpsExifTiff(binary.LittleEndian, 6))
+	xmp := webpChunk("XMP ", []byte("<x:xmpmeta/>"))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"simple format", testWebp(bitstream), testWebp(bitstream), false},
+		{
+			"exif and xmp",
+			testWebp(vp8x(webpFlagExif|webpFlagXmp), bitstream, exif, xmp),
+			testWebp(vp8x(0), bitstream), false,
+		},
+		{
+			"animation flag is kept",
+			testWebp(vp8x(webpFlagAnimation|webpFlagExif), bitstream, webpChunk("EXIF", gpsExifTiff(binary.BigEndian, 1))),
+			testWebp(vp8x(webpFlagAnimation), bitstream), false,
+		},
+		{"not a webp", []byte("RIFF\x00\x00\x00\x00WAVE"), nil, true},
+		{"truncated chunk", testWebp(vp8x(webpFlagExif), bitstream, exif)[:40], nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripWebpMetadata(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stripWebpMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("stripWebpMetadata() = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
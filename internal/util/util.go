@@ -10,8 +10,6 @@ import (
 	"strings"
 )
 
-const DevMode = false
-
 var (
 	STATIC_PATH   = "web/static"
 	DATABASE_PATH = "internal/database/comfychan.db"
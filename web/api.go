@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// -----------------
+// JSON MODELS
+// -----------------
+
+type apiBoard struct {
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+	Tag  string `json:"tag"`
+}
+
+type apiFile struct {
+	Id          int     `json:"id"`
+	Name        string  `json:"name"`
+	URL         string  `json:"url"`
+	DownloadURL string  `json:"download_url"`
+	ThumbURL    string  `json:"thumb_url"`
+	PreviewURL  string  `json:"preview_url,omitempty"` // animated webp of videos and gifs
+	MimeType    string  `json:"mime_type,omitempty"`
+	Type        string  `json:"type,omitempty"` // image, video, audio or pdf
+	Size        int64   `json:"size"`
+	Width       int     `json:"width,omitempty"`
+	Height      int     `json:"height,omitempty"`
+	Duration    float64 `json:"duration,omitempty"` // seconds
+	IsVideo     bool    `json:"is_video"`
+	Status      string  `json:"status"` // ready, pending or failed
+	Sha256      string  `json:"sha256,omitempty"`
+}
+
+type apiPost struct {
+	Id        int       `json:"id"`
+	Number    int       `json:"number"`
+	ThreadId  int       `json:"thread_id"`
+	Author    string    `json:"author"`
+	Tripcode  string    `json:"tripcode,omitempty"`
+	PosterId  string    `json:"poster_id,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	Banned    bool      `json:"banned"`
+	Sage      bool      `json:"sage"`
+	Spoiler   bool      `json:"spoiler"`
+	Files     []apiFile `json:"files"`
+}
+
+type apiThread struct {
+	Id         int       `json:"id"`
+	Board      string    `json:"board"`
+	Subject    string    `json:"subject"`
+	CreatedAt  time.Time `json:"created_at"`
+	BumpedAt   time.Time `json:"bumped_at"`
+	Pinned     bool      `json:"pinned"`
+	Locked     bool      `json:"locked"`
+	Archived   bool      `json:"archived"`
+	ReplyCount int       `json:"reply_count"`
+	IpCount    int       `json:"ip_count"`
+}
+
+type apiCatalogThread struct {
+	apiThread
+	Op apiPost `json:"op"`
+}
+
+type apiThreadWithPosts struct {
+	apiThread
+	Posts []apiPost `json:"posts"`
+}
+
+func toApiBoard(board database.Board) apiBoard {
+	return apiBoard{
+		Slug: board.Slug,
+		Name: board.Name,
+		Tag:  board.Tag,
+	}
+}
+
+func toApiPost(post database.Post, board database.Board) apiPost {
+	result := apiPost{
+		Id:        post.Id,
+		Number:    post.Number,
+		ThreadId:  post.ThreadId,
+		Author:    post.Author,
+		Tripcode:  post.Tripcode,
+		Body:      post.Body,
+		CreatedAt: post.CreatedAt,
+		Banned:    post.Banned,
+		Sage:      post.Sage,
+		Spoiler:   post.Spoiler,
+		Files:     make([]apiFile, 0, len(post.Files)),
+	}
+
+	if board.ShowPosterIds {
+		result.PosterId = post.PosterId
+	}
+
+	for _, file := range post.Files {
+		name := file.OriginalName
+		if name == "" {
+			name = file.MediaPath
+		}
+		result.Files = append(result.Files, apiFile{
+			Id:          file.Id,
+			Name:        name,
+			URL:         util.PostMediaURL(file.MediaPath),
+			DownloadURL: fmt.Sprintf("/files/%d", file.Id),
+			ThumbURL:    util.PostThumbURL(file.ThumbPath),
+			PreviewURL:  util.PostPreviewURL(file.PreviewPath),
+			MimeType:    file.Info.MimeType,
+			Type:        apiFileType(file),
+			Size:        file.Info.Size,
+			Width:       file.Info.Width,
+			Height:      file.Info.Height,
+			Duration:    file.Info.Duration.Seconds(),
+			IsVideo:     file.Info.IsVideo(),
+			Status:      file.MediaStatus,
+			Sha256:      file.Sha256,
+		})
+	}
+
+	return result
+}
+
+func toApiThread(thread database.Thread, posts []database.Post) apiThread {
+	uniqueIpHashes := map[string]bool{}
+	for _, post := range posts {
+		uniqueIpHashes[post.IpHash] = true
+	}
+
+	return apiThread{
+		Id:         thread.Id,
+		Board:      thread.BoardSlug,
+		Subject:    thread.Subject,
+		CreatedAt:  thread.CreatedAt,
+		BumpedAt:   thread.BumpedAt,
+		Pinned:     thread.Pinned,
+		Locked:     thread.Locked,
+		Archived:   thread.Archived,
+		ReplyCount: len(posts),
+		IpCount:    len(uniqueIpHashes),
+	}
+}
+
+// newest timestamp of the thread or any of its posts
+func threadLastModified(thread database.Thread, posts []database.Post) time.Time {
+	result := thread.BumpedAt
+	for _, post := range posts {
+		if post.CreatedAt.After(result) {
+			result = post.CreatedAt
+		}
+	}
+	return result
+}
+
+// -----------------
+// HELPERS
+// -----------------
+
+// writes v as json with an ETag of the body and, if non-zero, a Last-Modified
+// of lastModified. replies 304 when the client's copy is still current
+func writeApiJson(w http.ResponseWriter, r *http.Request, v any, lastModified time.Time) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeApiError(w, "Failed to encode response", http.StatusInternalServerError)
+		log.Printf("json.Marshal: %v", err)
+		return
+	}
+
+	checksum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(checksum[:16]) + `"`
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag || match == "W/"+etag || match == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.IsZero() {
+		if !lastModified.Truncate(time.Second).After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Write(body)
+}
+
+func writeApiError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+func apiCorsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// -----------------
+// ROUTES
+// -----------------
+
+func apiRoutes(db *sql.DB) func(r chi.Router) {
+	getBoard := func(w http.ResponseWriter, slug string) (database.Board, bool) {
+		board, err := database.GetBoard(db, slug)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeApiError(w, "Board not found", http.StatusNotFound)
+				return database.Board{}, false
+			}
+			writeApiError(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("GetBoard: %v", err)
+			return database.Board{}, false
+		}
+		return board, true
+	}
+
+	return func(r chi.Router) {
+		r.Use(apiCorsMiddleware)
+
+		// BOARDS
+		r.Get("/boards", func(w http.ResponseWriter, r *http.Request) {
+			boards, err := database.GetBoards(db)
+			if err != nil {
+				writeApiError(w, "Failed to get boards", http.StatusInternalServerError)
+				log.Printf("GetBoards: %v", err)
+				return
+			}
+
+			result := make([]apiBoard, 0, len(boards))
+			for _, board := range boards {
+				result = append(result, toApiBoard(board))
+			}
+
+			writeApiJson(w, r, result, time.Time{})
+		})
+
+		// BOARD
+		r.Get("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+			board, ok := getBoard(w, chi.URLParam(r, "slug"))
+			if !ok {
+				return
+			}
+
+			writeApiJson(w, r, toApiBoard(board), time.Time{})
+		})
+
+		// CATALOG
+		r.Get("/{slug}/catalog", func(w http.ResponseWriter, r *http.Request) {
+			board, ok := getBoard(w, chi.URLParam(r, "slug"))
+			if !ok {
+				return
+			}
+
+			threads, err := database.GetThreads(db, board.Slug)
+			if err != nil {
+				writeApiError(w, "Failed to get threads", http.StatusInternalServerError)
+				log.Printf("GetThreads: %v", err)
+				return
+			}
+
+			var lastModified time.Time
+			result := make([]apiCatalogThread, 0, len(threads))
+			for _, thread := range threads {
+				posts, err := database.GetPosts(db, thread.Id)
+				if err != nil {
+					writeApiError(w, "Failed to get posts", http.StatusInternalServerError)
+					log.Printf("GetPosts: %v", err)
+					return
+				}
+				if len(posts) == 0 {
+					log.Printf("Thread %d has no posts", thread.Id)
+					continue
+				}
+
+				if modified := threadLastModified(thread, posts); modified.After(lastModified) {
+					lastModified = modified
+				}
+
+				result = append(result, apiCatalogThread{
+					apiThread: toApiThread(thread, posts),
+					Op:        toApiPost(posts[0], board),
+				})
+			}
+
+			writeApiJson(w, r, result, lastModified)
+		})
+
+		// THREAD
+		r.Get("/{slug}/threads/{threadId}", func(w http.ResponseWriter, r *http.Request) {
+			threadIdStr := chi.URLParam(r, "threadId")
+			threadId, err := strconv.Atoi(threadIdStr)
+			if err != nil {
+				writeApiError(w, "Invalid thread id", http.StatusBadRequest)
+				return
+			}
+
+			board, ok := getBoard(w, chi.URLParam(r, "slug"))
+			if !ok {
+				return
+			}
+
+			thread, err := database.GetThread(db, threadId)
+			if err != nil || thread.BoardSlug != board.Slug {
+				if err == nil || errors.Is(err, sql.ErrNoRows) {
+					writeApiError(w, "Thread not found", http.StatusNotFound)
+					return
+				}
+				writeApiError(w, "Failed to get thread", http.StatusInternalServerError)
+				log.Printf("GetThread: %v", err)
+				return
+			}
+
+			posts, err := database.GetPosts(db, threadId)
+			if err != nil {
+				writeApiError(w, "Failed to get posts", http.StatusInternalServerError)
+				log.Printf("GetPosts: %v", err)
+				return
+			}
+
+			result := apiThreadWithPosts{
+				apiThread: toApiThread(thread, posts),
+				Posts:     make([]apiPost, 0, len(posts)),
+			}
+			for _, post := range posts {
+				result.Posts = append(result.Posts, toApiPost(post, board))
+			}
+
+			writeApiJson(w, r, result, threadLastModified(thread, posts))
+		})
+
+		// POST
+		r.Get("/{slug}/posts/{postId}", func(w http.ResponseWriter, r *http.Request) {
+			postIdStr := chi.URLParam(r, "postId")
+			postId, err := strconv.Atoi(postIdStr)
+			if err != nil {
+				writeApiError(w, "Invalid post id", http.StatusBadRequest)
+				return
+			}
+
+			board, ok := getBoard(w, chi.URLParam(r, "slug"))
+			if !ok {
+				return
+			}
+
+			post, err := database.GetPost(db, postId)
+			notFound := errors.Is(err, sql.ErrNoRows)
+			if err != nil && !notFound {
+				writeApiError(w, "Failed to get post", http.StatusInternalServerError)
+				log.Printf("GetPost: %v", err)
+				return
+			}
+
+			if !notFound {
+				thread, err := database.GetThread(db, post.ThreadId)
+				if err != nil {
+					writeApiError(w, "Failed to get thread", http.StatusInternalServerError)
+					log.Printf("GetThread: %v", err)
+					return
+				}
+				notFound = thread.BoardSlug != board.Slug
+			}
+
+			if notFound {
+				writeApiError(w, fmt.Sprintf("Post %d not found", postId), http.StatusNotFound)
+				return
+			}
+
+			writeApiJson(w, r, toApiPost(post, board), post.CreatedAt)
+		})
+
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			writeApiError(w, "Not found", http.StatusNotFound)
+		})
+	}
+}
+
+// empty for older files the backfill has not reached
+func apiFileType(file database.PostFile) string {
+	if file.Info.MimeType == "" {
+		return ""
+	}
+	return file.Info.MediaType().String()
+}
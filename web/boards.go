@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/dominicf2001/comfychan/web/views/admin"
+	"github.com/go-chi/chi/v5"
+)
+
+// parses and validates the board form shared by the create and edit routes.
+// the returned error is meant for the user
+func parseBoardForm(w http.ResponseWriter, r *http.Request) (database.Board, error) {
+	postLimits := util.GetConfig().Limits
+	r.Body = http.MaxBytesReader(w, r.Body, postLimits.MaxRequestBytes())
+	if err := r.ParseMultipartForm(int64(postLimits.MaxFileSize)); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) || errors.Is(err, multipart.ErrMessageTooLarge) {
+			return database.Board{}, fmt.Errorf("Image too large (max %s)", postLimits.MaxFileSize)
+		}
+		log.Printf("ParseMultipartForm: %v", err)
+		return database.Board{}, errors.New("Failed to parse form")
+	}
+
+	board := database.Board{
+		Slug:          strings.ToLower(strings.TrimSpace(r.FormValue("slug"))),
+		Name:          strings.TrimSpace(r.FormValue("name")),
+		Tag:           strings.TrimSpace(r.FormValue("tag")),
+		Nsfw:          r.FormValue("nsfw") == "on",
+		ShowPosterIds: r.FormValue("show_poster_ids") == "on",
+		StripMetadata: r.FormValue("strip_metadata") == "on",
+	}
+
+	for _, name := range r.Form["allowed_media_types"] {
+		board.AllowedMediaTypes = append(board.AllowedMediaTypes, util.ParsePostMediaTypes(name)...)
+	}
+
+	if err := util.ValidateBoardSlug(board.Slug); err != nil {
+		return database.Board{}, err
+	}
+	if board.Name == "" {
+		return database.Board{}, errors.New("Name is empty")
+	}
+	if len(board.Name) > util.MAX_BOARD_NAME_LEN {
+		return database.Board{}, fmt.Errorf("Name exceeds %d characters", util.MAX_BOARD_NAME_LEN)
+	}
+	if len(board.Tag) > util.MAX_BOARD_TAG_LEN {
+		return database.Board{}, fmt.Errorf("Tag exceeds %d characters", util.MAX_BOARD_TAG_LEN)
+	}
+	if len(board.AllowedMediaTypes) == 0 {
+		return database.Board{}, errors.New("Allow at least one file type")
+	}
+
+	limits := []struct {
+		field string
+		label string
+		dst   *int
+	}{
+		{"max_threads", "Max threads", &board.MaxThreads},
+		{"bump_limit", "Bump limit", &board.BumpLimit},
+		{"image_limit", "Image limit", &board.ImageLimit},
+		{"duplicate_window", "Duplicate window", &board.DuplicateWindow},
+	}
+	for _, limit := range limits {
+		value, err := strconv.Atoi(strings.TrimSpace(r.FormValue(limit.field)))
+		if err != nil || value < 0 {
+			return database.Board{}, fmt.Errorf("%s must be a number of at least 0", limit.label)
+		}
+		*limit.dst = value
+	}
+
+	return board, nil
+}
+
+// saves the image uploaded in field with save, if any. returns "" when none
+// was uploaded
+func saveBoardFormImage(
+	r *http.Request, field, slug string,
+	save func(file multipart.File, slug, originalName string) (string, error),
+) (string, error) {
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		if errors.Is(err, http.ErrMissingFile) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer file.Close()
+
+	return save(file, slug, header.Filename)
+}
+
+func adminBoardRoutes(db *sql.DB) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			boards, err := database.GetBoards(db)
+			if err != nil {
+				http.Error(w, "Failed to get boards", http.StatusInternalServerError)
+				log.Printf("GetBoards: %v", err)
+				return
+			}
+
+			admin.AdminBoards(boards).Render(r.Context(), w)
+		})
+
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			board, err := parseBoardForm(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if _, err := database.GetBoard(db, board.Slug); err == nil {
+				http.Error(w, fmt.Sprintf("Board /%s/ already exists", board.Slug), http.StatusBadRequest)
+				return
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Failed to get board", http.StatusInternalServerError)
+				log.Printf("GetBoard: %v", err)
+				return
+			}
+
+			board.BannerPath, err = saveBoardFormImage(r, "banner", board.Slug, util.SaveBannerFile)
+			if err != nil {
+				http.Error(w, "Failed to save banner", http.StatusBadRequest)
+				log.Printf("saveBoardFormImage (banner): %v", err)
+				return
+			}
+
+			board.SpoilerPath, err = saveBoardFormImage(r, "spoiler", board.Slug, util.SaveSpoilerFile)
+			if err != nil {
+				util.DeleteBannerFile(board.BannerPath)
+				http.Error(w, "Failed to save spoiler image", http.StatusBadRequest)
+				log.Printf("saveBoardFormImage (spoiler): %v", err)
+				return
+			}
+
+			if err := database.PutBoard(db, board); err != nil {
+				util.DeleteBannerFile(board.BannerPath)
+				util.DeleteSpoilerFile(board.SpoilerPath)
+				http.Error(w, "Failed to create board", http.StatusInternalServerError)
+				log.Printf("PutBoard: %v", err)
+				return
+			}
+		})
+
+		r.Put("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+			slug := chi.URLParam(r, "slug")
+
+			existing, err := database.GetBoard(db, slug)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, fmt.Sprintf("Board /%s/ not found", slug), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Failed to get board", http.StatusInternalServerError)
+				log.Printf("GetBoard: %v", err)
+				return
+			}
+
+			board, err := parseBoardForm(w, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if board.Slug != slug {
+				if _, err := database.GetBoard(db, board.Slug); err == nil {
+					http.Error(w, fmt.Sprintf("Board /%s/ already exists", board.Slug), http.StatusBadRequest)
+					return
+				} else if !errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "Failed to get board", http.StatusInternalServerError)
+					log.Printf("GetBoard: %v", err)
+					return
+				}
+			}
+
+			board.BannerPath, err = saveBoardFormImage(r, "banner", board.Slug, util.SaveBannerFile)
+			if err != nil {
+				http.Error(w, "Failed to save banner", http.StatusBadRequest)
+				log.Printf("saveBoardFormImage (banner): %v", err)
+				return
+			}
+			if board.BannerPath == "" {
+				board.BannerPath = existing.BannerPath
+			}
+
+			board.SpoilerPath, err = saveBoardFormImage(r, "spoiler", board.Slug, util.SaveSpoilerFile)
+			if err != nil {
+				if board.BannerPath != existing.BannerPath {
+					util.DeleteBannerFile(board.BannerPath)
+				}
+				http.Error(w, "Failed to save spoiler image", http.StatusBadRequest)
+				log.Printf("saveBoardFormImage (spoiler): %v", err)
+				return
+			}
+			if board.SpoilerPath == "" {
+				board.SpoilerPath = existing.SpoilerPath
+			}
+
+			if err := database.UpdateBoard(db, slug, board); err != nil {
+				if board.BannerPath != existing.BannerPath {
+					util.DeleteBannerFile(board.BannerPath)
+				}
+				if board.SpoilerPath != existing.SpoilerPath {
+					util.DeleteSpoilerFile(board.SpoilerPath)
+				}
+				http.Error(w, "Failed to update board", http.StatusInternalServerError)
+				log.Printf("UpdateBoard: %v", err)
+				return
+			}
+
+			if board.BannerPath != existing.BannerPath {
+				if err := util.DeleteBannerFile(existing.BannerPath); err != nil {
+					log.Printf("DeleteBannerFile: %v", err)
+				}
+			}
+			if board.SpoilerPath != existing.SpoilerPath {
+				if err := util.DeleteSpoilerFile(existing.SpoilerPath); err != nil {
+					log.Printf("DeleteSpoilerFile: %v", err)
+				}
+			}
+		})
+
+		r.Delete("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+			slug := chi.URLParam(r, "slug")
+
+			board, err := database.GetBoard(db, slug)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, fmt.Sprintf("Board /%s/ not found", slug), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Failed to get board", http.StatusInternalServerError)
+				log.Printf("GetBoard: %v", err)
+				return
+			}
+
+			if err := database.DeleteBoard(db, slug); err != nil {
+				http.Error(w, "Failed to delete board", http.StatusInternalServerError)
+				log.Printf("DeleteBoard: %v", err)
+				return
+			}
+
+			if err := util.DeleteBannerFile(board.BannerPath); err != nil {
+				log.Printf("DeleteBannerFile: %v", err)
+			}
+			if err := util.DeleteSpoilerFile(board.SpoilerPath); err != nil {
+				log.Printf("DeleteSpoilerFile: %v", err)
+			}
+		})
+	}
+}
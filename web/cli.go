@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+)
+
+const cliUsage = `usage:
+  comfychan                        start the server
+  comfychan migrate status         list migrations and whether they are applied
+  comfychan migrate up             apply all pending migrations
+  comfychan migrate down [steps]   revert the latest migration(s) (default 1)
+  comfychan media backfill         record the size, dimensions and duration of older files`
+
+var errCliUsage = errors.New(cliUsage)
+
+func runCli(db *sql.DB, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrateCli(db, args[1:])
+	case "media":
+		return runMediaCli(db, args[1:])
+	default:
+		return errCliUsage
+	}
+}
+
+func runMigrateCli(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errCliUsage
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := database.GetMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%04d_%-30s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+
+	case "up":
+		applied, err := database.MigrateUp(db)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+
+		reverted, err := database.MigrateDown(db, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("no applied migrations")
+		}
+		return nil
+
+	default:
+		return errCliUsage
+	}
+}
+
+func runMediaCli(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errCliUsage
+	}
+
+	switch args[0] {
+	case "backfill":
+		files, err := database.GetPostFilesMissingInfo(db)
+		if err != nil {
+			return err
+		}
+
+		// a missing or unreadable file should not stop the rest
+		failed := 0
+		for _, file := range files {
+			info, err := util.GetPostFileInfo(file.MediaPath)
+			if err != nil {
+				fmt.Printf("skipped %s: %v\n", file.MediaPath, err)
+				failed++
+				continue
+			}
+			if err := database.SetPostFileInfo(db, file.MediaPath, info); err != nil {
+				return err
+			}
+			fmt.Printf("recorded %s %s\n", file.MediaPath, util.FormatPostFileInfo(info))
+		}
+
+		fmt.Printf("backfilled %d of %d files\n", len(files)-failed, len(files))
+		return nil
+
+	default:
+		return errCliUsage
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/dominicf2001/comfychan/internal/util"
+)
+
+// read from the data directory unless COMFYCHAN_CONFIG names another file
+const CONFIG_FILE_NAME = "comfychan.toml"
+
+// reloads the config whenever the process receives SIGHUP. an invalid config
+// is logged and the current one kept. dev_mode keeps its value from startup
+func reloadConfigOnSighup(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		config, err := util.LoadConfig(path)
+		if err != nil {
+			log.Printf("Keeping the current config, reload failed: %v", err)
+			continue
+		}
+
+		current := util.GetConfig()
+		if config.Listen != current.Listen || config.Media.Workers != current.Media.Workers {
+			log.Printf("Changes to listen and media.workers apply after a restart")
+		}
+		// a reload must never open the admin panel to everyone
+		if config.DevMode != current.DevMode {
+			log.Printf("Changes to dev_mode apply after a restart")
+		}
+		config.DevMode = current.DevMode
+		if config.Media.Store != current.Media.Store || config.Media.S3 != current.Media.S3 {
+			log.Printf("Changes to media.store and media.s3 apply after a restart")
+		}
+
+		util.SetConfig(config)
+		log.Printf("Reloaded config from %s", path)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+)
+
+type postFormFile struct {
+	header *multipart.FileHeader
+	hash   util.PostFileHash
+}
+
+// validates and hashes the files attached to a post form without saving them.
+// the returned error is meant for the user, along with the status to send it with
+func postFormFiles(r *http.Request, db *sql.DB, board database.Board) ([]postFormFile, int, error) {
+	var headers []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		headers = r.MultipartForm.File["file"]
+	}
+
+	limits := util.GetConfig().BoardLimits(board.Slug)
+	if len(headers) > limits.MaxFilesPerPost {
+		return nil, http.StatusBadRequest, fmt.Errorf("Too many files (max %d)", limits.MaxFilesPerPost)
+	}
+
+	result := make([]postFormFile, 0, len(headers))
+	for _, header := range headers {
+		if header.Size > int64(limits.MaxFileSize) {
+			return nil, http.StatusRequestEntityTooLarge,
+				fmt.Errorf("File %q too large (max %s)", header.Filename, limits.MaxFileSize)
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			log.Printf("FileHeader.Open: %v", err)
+			return nil, http.StatusBadRequest, errors.New("Failed to retrieve file from form")
+		}
+		mediaType, err := util.DetectPostFileType(file)
+		if err != nil {
+			file.Close()
+			log.Printf("DetectPostFileType: %v", err)
+			return nil, http.StatusInternalServerError, errors.New("Failed to detect file type")
+		}
+		if mediaType == util.PostFileUnsupported {
+			file.Close()
+			return nil, http.StatusBadRequest, fmt.Errorf("Unsupported media type: %q", header.Filename)
+		}
+		if !slices.Contains(board.AllowedMediaTypes, mediaType) {
+			file.Close()
+			return nil, http.StatusBadRequest, fmt.Errorf("File %q is not allowed, /%s/ does not accept %s files",
+				header.Filename, board.Slug, mediaType)
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			log.Printf("Seek: %v", err)
+			return nil, http.StatusInternalServerError, errors.New("Failed to read file")
+		}
+		hash, err := util.HashPostFile(file)
+		file.Close()
+		if err != nil {
+			log.Printf("HashPostFile: %v", err)
+			return nil, http.StatusInternalServerError, errors.New("Failed to read file")
+		}
+
+		// guard banned files
+		ban, err := database.FindBannedHash(db, hash)
+		if err == nil {
+			msg := fmt.Sprintf("File %q is banned", header.Filename)
+			if ban.Reason != "" {
+				msg += ". Reason: " + ban.Reason
+			}
+			return nil, http.StatusForbidden, errors.New(msg)
+		}
+		if !errors.Is(err, database.ErrBannedHashNotFound) {
+			log.Printf("FindBannedHash: %v", err)
+			return nil, http.StatusInternalServerError, errors.New("Failed to check file")
+		}
+
+		if board.DuplicateWindow > 0 {
+			window := time.Duration(board.DuplicateWindow) * time.Minute
+			duplicate, err := database.HasRecentDuplicate(db, board.Slug, hash.Sha256, window)
+			if err != nil {
+				log.Printf("HasRecentDuplicate: %v", err)
+				return nil, http.StatusInternalServerError, errors.New("Failed to check file")
+			}
+			if duplicate {
+				return nil, http.StatusBadRequest, fmt.Errorf("File %q was already posted in the last %d minutes",
+					header.Filename, board.DuplicateWindow)
+			}
+		}
+
+		result = append(result, postFormFile{header: header, hash: hash})
+	}
+
+	return result, http.StatusOK, nil
+}
+
+// saves every file in files, reusing stored media with the same content hash.
+// if one fails, the files saved so far are released
+func savePostFiles(db *sql.DB, board database.Board, files []postFormFile) ([]database.PostFile, error) {
+	opts := util.UploadOptions{StripMetadata: board.StripMetadata}
+
+	result := make([]database.PostFile, 0, len(files))
+	for i, f := range files {
+		savedFile, err := findPostFileByHash(db, result, f.hash.Sha256, opts.StripMetadata)
+		if errors.Is(err, sql.ErrNoRows) {
+			savedFile, err = savePostFile(f.header, f.hash.Sha256, opts)
+		}
+		if err == nil {
+			// media released by a deleted post must outlive this one being made
+			err = database.ReclaimMedia(db, savedFile.MediaPath)
+		}
+		if err != nil {
+			deletePostFiles(db, result)
+			return nil, err
+		}
+
+		result = append(result, database.PostFile{
+			Position:         i,
+			MediaPath:        savedFile.MediaPath,
+			ThumbPath:        savedFile.ThumbPath,
+			PreviewPath:      savedFile.PreviewPath,
+			Sha256:           f.hash.Sha256,
+			Phash:            f.hash.Phash,
+			OriginalName:     util.SanitizeOriginalName(f.header.Filename),
+			MetadataStripped: savedFile.MetadataStripped,
+			Info:             savedFile.Info,
+			MediaStatus:      savedFile.MediaStatus,
+		})
+	}
+
+	return result, nil
+}
+
+// looks in the files of the post being created before the stored ones, so the
+// same file attached twice is only saved once
+func findPostFileByHash(db *sql.DB, pending []database.PostFile, sha256 string, requireStripped bool) (database.PostFile, error) {
+	for _, f := range pending {
+		if f.Sha256 == sha256 {
+			return f, nil
+		}
+	}
+	return database.GetPostFileByHash(db, sha256, requireStripped)
+}
+
+// stores the upload under a name derived from its content hash, so client
+// file names never reach the filesystem
+func savePostFile(header *multipart.FileHeader, baseName string, opts util.UploadOptions) (database.PostFile, error) {
+	file, err := header.Open()
+	if err != nil {
+		return database.PostFile{}, err
+	}
+	defer file.Close()
+
+	err, mediaPath, thumbPath, info := util.SavePostFile(file, baseName, opts)
+	if err != nil {
+		return database.PostFile{}, err
+	}
+
+	// files left for a media job have no thumbnail yet
+	status := database.MediaStatusReady
+	if thumbPath == "" {
+		status = database.MediaStatusPending
+	}
+
+	return database.PostFile{
+		MediaPath:        mediaPath,
+		ThumbPath:        thumbPath,
+		MetadataStripped: opts.StripMetadata,
+		Info:             info,
+		MediaStatus:      status,
+	}, nil
+}
+
+// releases files of a post that failed to be created. media shared with
+// existing posts is kept
+func deletePostFiles(db *sql.DB, files []database.PostFile) {
+	if err := database.ReleasePostFiles(db, files); err != nil {
+		log.Printf("ReleasePostFiles: %v", err)
+	}
+}
+
+// picks where post media is stored from the media.store setting, either the
+// data directory or an S3 compatible bucket
+func mediaStoreFromConfig(c *util.Config) util.MediaStore {
+	if c.Media.Store == "s3" {
+		s3 := c.Media.S3
+		return &util.S3MediaStore{
+			Endpoint:  s3.Endpoint,
+			Bucket:    s3.Bucket,
+			Region:    s3.Region,
+			AccessKey: s3.AccessKey,
+			SecretKey: s3.SecretKey,
+			PublicURL: s3.PublicURL,
+			Client:    &http.Client{Timeout: time.Minute},
+		}
+	}
+	return &util.LocalMediaStore{Root: util.MEDIA_PATH, URLPrefix: "/media"}
+}
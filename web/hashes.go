@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/web/views/admin"
+	"github.com/go-chi/chi/v5"
+)
+
+func adminHashRoutes(db *sql.DB) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			bans, err := database.GetBannedHashes(db)
+			if err != nil {
+				http.Error(w, "Failed to get banned files", http.StatusInternalServerError)
+				log.Printf("GetBannedHashes: %v", err)
+				return
+			}
+
+			admin.AdminHashes(bans).Render(r.Context(), w)
+		})
+
+		// bans the hash of a post file so it can no longer be uploaded. the
+		// reason comes from the htmx prompt
+		r.Post("/files/{fileId}", func(w http.ResponseWriter, r *http.Request) {
+			fileIdStr := chi.URLParam(r, "fileId")
+			fileId, err := strconv.Atoi(fileIdStr)
+			if err != nil {
+				http.Error(w, "Invalid file id", http.StatusBadRequest)
+				return
+			}
+
+			file, err := database.GetPostFile(db, fileId)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "File not found", http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Failed to get file", http.StatusInternalServerError)
+				log.Printf("GetPostFile: %v", err)
+				return
+			}
+
+			if file.Sha256 == "" {
+				http.Error(w, "File was uploaded before hashing and cannot be banned", http.StatusBadRequest)
+				return
+			}
+
+			reason := strings.TrimSpace(r.Header.Get("HX-Prompt"))
+			if err := database.BanHash(db, file.Sha256, file.Phash, reason); err != nil {
+				http.Error(w, "Failed to ban file", http.StatusInternalServerError)
+				log.Printf("BanHash: %v", err)
+				return
+			}
+
+			entry := database.ModLogEntry{
+				Action: database.ModActionBanFile,
+				Reason: reason,
+				Snapshot: marshalSnapshot(fileSnapshot{
+					Name:   cmp.Or(file.OriginalName, file.MediaPath),
+					Sha256: file.Sha256,
+				}),
+			}
+			if post, err := database.GetPost(db, file.PostId); err == nil {
+				entry.ThreadId = post.ThreadId
+				entry.PostNumber = post.Number
+				if thread, err := database.GetThread(db, post.ThreadId); err == nil {
+					entry.BoardSlug = thread.BoardSlug
+				}
+			}
+			logModAction(r, db, entry)
+		})
+
+		r.Delete("/{banId}", func(w http.ResponseWriter, r *http.Request) {
+			banIdStr := chi.URLParam(r, "banId")
+			banId, err := strconv.Atoi(banIdStr)
+			if err != nil {
+				http.Error(w, "Invalid ban id", http.StatusBadRequest)
+				return
+			}
+
+			ban, err := database.GetBannedHash(db, banId)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "Ban not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Failed to get ban", http.StatusInternalServerError)
+				log.Printf("GetBannedHash: %v", err)
+				return
+			}
+
+			if err := database.UnbanHash(db, banId); err != nil {
+				http.Error(w, "Failed to unban file", http.StatusInternalServerError)
+				log.Printf("UnbanHash: %v", err)
+				return
+			}
+
+			logModAction(r, db, database.ModLogEntry{
+				Action:   database.ModActionUnbanFile,
+				Reason:   ban.Reason,
+				Snapshot: marshalSnapshot(fileSnapshot{Sha256: ban.Sha256}),
+			})
+		})
+	}
+}
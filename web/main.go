@@ -1,11 +1,14 @@
 package main
 
 import (
+	"cmp"
 	"database/sql"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -18,6 +21,7 @@ import (
 	"github.com/dominicf2001/comfychan/internal/util"
 	"github.com/dominicf2001/comfychan/web/views"
 	"github.com/dominicf2001/comfychan/web/views/admin"
+	"github.com/dominicf2001/comfychan/web/views/shared"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,8 +30,7 @@ import (
 
 func AdminOnlyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c, err := r.Cookie("comfy_admin")
-		if !util.DevMode && (err != nil || !util.IsAdminSessionValid(c.Value)) {
+		if !isAdmin(r) {
 			// Check if it's an HTMX request
 			if r.Header.Get("HX-Request") == "true" {
 				w.Header().Set("HX-Redirect", "/authorize")
@@ -42,25 +45,113 @@ func AdminOnlyMiddleware(next http.Handler) http.Handler {
 }
 
 func disableCacheInDevMode(next http.Handler) http.Handler {
-	if !util.DevMode {
-		return next
-	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
+		if util.GetConfig().DevMode {
+			w.Header().Set("Cache-Control", "no-store")
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
 func isAdmin(r *http.Request) bool {
-	if util.DevMode {
-		return true
+	_, ok := getAdmin(r)
+	return ok
+}
+
+// responds with 403 unless the staff member's role grants p. for routes that
+// are not tied to a board
+func RequirePermissionMiddleware(p util.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if admin, _ := getAdmin(r); !admin.Role.Can(p) {
+				http.Error(w, "You are not allowed to do that", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// whether the request comes from staff allowed to use p on the board
+func hasPermission(r *http.Request, p util.Permission, boardSlug string) bool {
+	admin, ok := getAdmin(r)
+	return ok && admin.Can(p, boardSlug)
+}
+
+// whether the request comes from staff moderating the board
+func moderatesBoard(r *http.Request, boardSlug string) bool {
+	admin, ok := getAdmin(r)
+	return ok && admin.Moderates(boardSlug)
+}
+
+// checks that the staff member may use p on the board of the thread and
+// returns the thread. responds with the error and returns false if not
+func checkThreadPermission(w http.ResponseWriter, r *http.Request, db *sql.DB, p util.Permission, threadId int) (database.Thread, bool) {
+	thread, err := database.GetThread(db, threadId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return database.Thread{}, false
+		}
+		http.Error(w, "Failed to get thread", http.StatusInternalServerError)
+		log.Printf("GetThread: %v", err)
+		return database.Thread{}, false
+	}
+
+	if !hasPermission(r, p, thread.BoardSlug) {
+		http.Error(w, "You are not allowed to do that", http.StatusForbidden)
+		return database.Thread{}, false
+	}
+	return thread, true
+}
+
+func modPermissions(r *http.Request, boardSlug string) views.ModPermissions {
+	return views.ModPermissions{
+		Delete:  hasPermission(r, util.PermDelete, boardSlug),
+		Ban:     hasPermission(r, util.PermBan, boardSlug),
+		PinLock: hasPermission(r, util.PermPinLock, boardSlug),
+	}
+}
+
+func pageContextMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admin, _ := getAdmin(r)
+			ctx := shared.WithPageContext(r.Context(), shared.PageContext{
+				IsAdmin: isAdmin(r),
+				Role:    admin.Role,
+				LoadBoards: func() ([]database.Board, error) {
+					return database.GetBoards(db)
+				},
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func newThreadContext(r *http.Request, board database.Board) views.ThreadContext {
+	return views.ThreadContext{
+		Mod:           modPermissions(r, board.Slug),
+		ShowPosterIds: board.ShowPosterIds,
+		BumpLimit:     board.BumpLimit,
+		ImageLimit:    board.ImageLimit,
+		SpoilerPath:   board.SpoilerPath,
 	}
+}
 
-	admin := false
-	if c, err := r.Cookie("comfy_admin"); err == nil {
-		admin = util.IsAdminSessionValid(c.Value)
+// hashes the client's ip and the range it falls in. a client whose ip can't
+// be parsed, like from a malformed X-Forwarded-For, could not be range banned,
+// so its request is refused
+func clientIpHashes(w http.ResponseWriter, r *http.Request, ipRangeKey string) (string, string, bool) {
+	ip := util.GetIP(r)
+	ipRangeHash := util.HashIpRange(ip, ipRangeKey)
+	if ipRangeHash == "" {
+		log.Printf("Refused request from unparsable ip %q", ip)
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "Could not determine your ip address", http.StatusBadRequest)
+		return "", "", false
 	}
-	return admin
+	return util.HashIp(ip), ipRangeHash, true
 }
 
 func main() {
@@ -75,10 +166,29 @@ func main() {
 		dataDir = "." // for development
 	}
 
-	util.POST_MEDIA_FULL_PATH = filepath.Join(dataDir, util.POST_MEDIA_FULL_PATH)
-	util.POST_MEDIA_THUMB_PATH = filepath.Join(dataDir, util.POST_MEDIA_THUMB_PATH)
+	util.MEDIA_PATH = filepath.Join(dataDir, util.MEDIA_PATH)
 	util.DATABASE_PATH = filepath.Join(dataDir, util.DATABASE_PATH)
 	util.STATIC_PATH = filepath.Join(dataDir, util.STATIC_PATH)
+	util.BANNER_MEDIA_PATH = filepath.Join(dataDir, util.BANNER_MEDIA_PATH)
+	util.SPOILER_MEDIA_PATH = filepath.Join(dataDir, util.SPOILER_MEDIA_PATH)
+
+	// init config
+
+	configPath := os.Getenv("COMFYCHAN_CONFIG")
+	if configPath == "" {
+		configPath = filepath.Join(dataDir, CONFIG_FILE_NAME)
+	}
+
+	config, err := util.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	util.SetConfig(config)
+	if config.DevMode {
+		log.Printf("WARNING: dev mode is on. every visitor is treated as an owner of the admin panel and admin cookies are not secure. never enable it on a public server")
+	}
+
+	util.MEDIA_STORE = mediaStoreFromConfig(config)
 
 	db, err := sql.Open("sqlite3", util.DATABASE_PATH+"?_foreign_keys=on")
 	if err != nil {
@@ -86,10 +196,51 @@ func main() {
 	}
 	defer db.Close()
 
+	// migrate commands run against the schema as it is, so a bad migration
+	// can be reverted. every other command needs it up to date
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runCli(db, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	applied, err := database.MigrateUp(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+	}
+
+	if len(os.Args) > 1 {
+		if err := runCli(db, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	startMediaWorkers(db)
+
+	tripcodeSecret, err := database.GetSecret(db, "tripcode")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// never rotated, since range bans would stop matching
+	ipRangeKey, err := database.GetSecret(db, "ip_range")
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	r := chi.NewRouter()
 
 	r.Use(middleware.Logger)
 
+	// static files, media and the api don't look at the admin session, so
+	// only pages pay for its lookups
+	pages := r.With(adminSessionMiddleware(db), pageContextMiddleware(db))
+
 	r.Handle("/static/*",
 		disableCacheInDevMode(
 			http.StripPrefix("/static/",
@@ -98,7 +249,59 @@ func main() {
 	r.Handle("/media/*",
 		disableCacheInDevMode(
 			http.StripPrefix("/media/",
-				http.FileServer(http.Dir(filepath.Join(dataDir, "media"))))))
+				http.FileServer(http.Dir(util.MEDIA_PATH)))))
+
+	// serves a post file under the name it was uploaded with
+	r.Get("/files/{fileId}", func(w http.ResponseWriter, r *http.Request) {
+		fileIdStr := chi.URLParam(r, "fileId")
+		fileId, err := strconv.Atoi(fileIdStr)
+		if err != nil {
+			http.Error(w, "Invalid file id", http.StatusBadRequest)
+			return
+		}
+
+		file, err := database.GetPostFile(db, fileId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "Failed to get file", http.StatusInternalServerError)
+			log.Printf("GetPostFile: %v", err)
+			return
+		}
+
+		name := file.OriginalName
+		if name == "" {
+			name = file.MediaPath
+		}
+
+		object, err := util.MEDIA_STORE.Open(util.PostMediaKey(file.MediaPath))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, "Failed to open file", http.StatusInternalServerError)
+			log.Printf("MEDIA_STORE.Open: %v", err)
+			return
+		}
+		defer object.Close()
+
+		w.Header().Set("Content-Type", cmp.Or(file.Info.MimeType, mime.TypeByExtension(filepath.Ext(file.MediaPath))))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+
+		// local files support range requests, which video seeking relies on
+		if seeker, ok := object.ReadCloser.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, file.MediaPath, time.Time{}, seeker)
+			return
+		}
+
+		if object.Size >= 0 {
+			w.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+		}
+		io.Copy(w, object)
+	})
 
 	// -----------------
 
@@ -107,12 +310,12 @@ func main() {
 	// -----------------
 
 	// INDEX PAGE
-	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+	pages.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		views.Index().Render(r.Context(), w)
 	})
 
 	// MAIN BOARD PAGE
-	r.Get("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+	pages.Get("/{slug}", func(w http.ResponseWriter, r *http.Request) {
 		slug := chi.URLParam(r, "slug")
 
 		board, err := database.GetBoard(db, slug)
@@ -129,8 +332,33 @@ func main() {
 		views.Board(board, isAdmin(r)).Render(r.Context(), w)
 	})
 
+	// ARCHIVE PAGE
+	pages.Get("/{slug}/archive", func(w http.ResponseWriter, r *http.Request) {
+		slug := chi.URLParam(r, "slug")
+
+		board, err := database.GetBoard(db, slug)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				views.NotFound().Render(r.Context(), w)
+				return
+			}
+			http.Error(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("Failed to get board%q: %v", slug, err)
+			return
+		}
+
+		threads, err := database.GetArchivedThreads(db, slug)
+		if err != nil {
+			http.Error(w, "Failed to get archived threads", http.StatusInternalServerError)
+			log.Printf("GetArchivedThreads: %v", err)
+			return
+		}
+
+		views.Archive(board, threads).Render(r.Context(), w)
+	})
+
 	// THREAD PAGE
-	r.Get("/{slug}/threads/{threadId}", func(w http.ResponseWriter, r *http.Request) {
+	pages.Get("/{slug}/threads/{threadId}", func(w http.ResponseWriter, r *http.Request) {
 		slug := chi.URLParam(r, "slug")
 		threadIdStr := chi.URLParam(r, "threadId")
 		threadId, err := strconv.Atoi(threadIdStr)
@@ -168,24 +396,30 @@ func main() {
 			return
 		}
 
-		if len(posts) == 0 || posts[0].MediaPath == "" {
+		// archived threads may have had their media purged
+		if len(posts) == 0 || (len(posts[0].Files) == 0 && !thread.Archived) {
 			http.Error(w, "Malformed thread", http.StatusInternalServerError)
 			log.Printf("Thread %d has no posts or no OP image", threadId)
 			return
 		}
 
-		views.Thread(board, thread, posts, views.ThreadContext{
-			IsAdmin: isAdmin(r),
-		}).Render(r.Context(), w)
+		views.Thread(board, thread, posts, newThreadContext(r, board)).Render(r.Context(), w)
 	})
 
 	// CREATE THREAD
-	r.Post("/{slug}/threads", func(w http.ResponseWriter, r *http.Request) {
+	pages.Post("/{slug}/threads", func(w http.ResponseWriter, r *http.Request) {
 		slug := chi.URLParam(r, "slug")
-		ipHash := util.HashIp(util.GetIP(r))
+		ipHash, ipRangeHash, ok := clientIpHashes(w, r, ipRangeKey)
+		if !ok {
+			return
+		}
+		limits := util.GetConfig().BoardLimits(slug)
 
-		// guard banned ips
+		// guard banned ips and ranges
 		ban, err := database.GetBan(db, ipHash)
+		if errors.Is(err, database.ErrBanNotFound) {
+			ban, err = database.GetRangeBan(db, ipRangeHash)
+		}
 		if err != nil {
 			if !errors.Is(err, database.ErrBanNotFound) {
 				http.Error(w, "Failed to get ban", http.StatusInternalServerError)
@@ -200,8 +434,8 @@ func main() {
 		}
 
 		// check cooldown
-		timeRemaining := util.GetRemainingCooldown(ipHash, util.ThreadCooldowns, util.THREAD_COOLDOWN)
-		if timeRemaining > 0 && !isAdmin(r) {
+		timeRemaining := util.GetRemainingCooldown(ipHash, util.ThreadCooldowns, limits.ThreadCooldown)
+		if timeRemaining > 0 && !moderatesBoard(r, slug) {
 			response := fmt.Sprintf("Please wait %.0f seconds", timeRemaining.Seconds())
 			io.Copy(io.Discard, r.Body)
 			http.Error(w, response, http.StatusTooManyRequests)
@@ -209,16 +443,16 @@ func main() {
 		}
 
 		// parse form
-		r.Body = http.MaxBytesReader(w, r.Body, util.MAX_REQUEST_BYTES)
-		if err := r.ParseMultipartForm(util.FILE_MEM_LIMIT); err != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBytes())
+		if err := r.ParseMultipartForm(int64(limits.MaxFileSize)); err != nil {
 			var maxErr *http.MaxBytesError
 			if errors.As(err, &maxErr) {
-				http.Error(w, fmt.Sprintf("File too large (max %s)", util.FormatBytes(util.FILE_MEM_LIMIT)), http.StatusRequestEntityTooLarge)
+				http.Error(w, fmt.Sprintf("File too large (max %s)", limits.MaxFileSize), http.StatusRequestEntityTooLarge)
 				return
 			}
 
 			if errors.Is(err, multipart.ErrMessageTooLarge) {
-				http.Error(w, fmt.Sprintf("File too large (max %s)", util.FormatBytes(util.FILE_MEM_LIMIT)), http.StatusRequestEntityTooLarge)
+				http.Error(w, fmt.Sprintf("File too large (max %s)", limits.MaxFileSize), http.StatusRequestEntityTooLarge)
 				return
 			}
 			http.Error(w, "Failed to parse form", http.StatusBadRequest)
@@ -227,68 +461,103 @@ func main() {
 		}
 
 		// validate inputs
+		name := strings.TrimSpace(r.FormValue("name"))
+		optionsStr := strings.TrimSpace(r.FormValue("options"))
 		subject := strings.TrimSpace(r.FormValue("subject"))
 		body := strings.TrimSpace(r.FormValue("body"))
 
-		if len(subject) > util.MAX_SUBJECT_LEN {
-			http.Error(w, fmt.Sprintf("Subject exceeds %d characters", util.MAX_SUBJECT_LEN), http.StatusBadRequest)
+		if len(name) > util.MAX_NAME_LEN {
+			http.Error(w, fmt.Sprintf("Name exceeds %d characters", util.MAX_NAME_LEN), http.StatusBadRequest)
 			return
 		}
 
-		if body == "" {
-			http.Error(w, "Body is empty", http.StatusBadRequest)
+		if len(optionsStr) > util.MAX_OPTIONS_LEN {
+			http.Error(w, fmt.Sprintf("Options exceed %d characters", util.MAX_OPTIONS_LEN), http.StatusBadRequest)
 			return
 		}
+		options := util.ParsePostOptions(optionsStr)
 
-		if len(body) > util.MAX_BODY_LEN {
-			http.Error(w, fmt.Sprintf("Body exceeds %d characters", util.MAX_BODY_LEN), http.StatusBadRequest)
+		if len(subject) > limits.MaxSubjectLen {
+			http.Error(w, fmt.Sprintf("Subject exceeds %d characters", limits.MaxSubjectLen), http.StatusBadRequest)
 			return
 		}
 
-		file, header, err := r.FormFile("file")
-		if err != nil {
-			http.Error(w, "Failed to retrive file from form", http.StatusBadRequest)
-			log.Printf("FormFile: %v", err)
+		if body == "" {
+			http.Error(w, "Body is empty", http.StatusBadRequest)
+			return
+		}
+
+		if len(body) > limits.MaxBodyLen {
+			http.Error(w, fmt.Sprintf("Body exceeds %d characters", limits.MaxBodyLen), http.StatusBadRequest)
 			return
 		}
-		defer file.Close()
 
-		if header.Size > util.FILE_MEM_LIMIT {
-			http.Error(w, fmt.Sprintf("File too large (max %s)", util.FormatBytes(util.FILE_MEM_LIMIT)), http.StatusRequestEntityTooLarge)
+		board, err := database.GetBoard(db, slug)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, fmt.Sprintf("Board /%s/ not found", slug), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("GetBoard: %v", err)
 			return
 		}
 
-		mediaType, err := util.DetectPostFileType(file)
+		formFiles, code, err := postFormFiles(r, db, board)
 		if err != nil {
-			http.Error(w, "Failed to detect if file is a video", http.StatusInternalServerError)
+			http.Error(w, err.Error(), code)
 			return
 		}
-		file.Seek(0, io.SeekStart)
 
-		if mediaType == util.PostFileUnsupported {
-			http.Error(w, "Unsupported media type", http.StatusBadRequest)
+		if len(formFiles) == 0 {
+			http.Error(w, "A thread requires at least one file", http.StatusBadRequest)
 			return
 		}
 
-		filename := strconv.FormatInt(time.Now().UnixNano(), 10) + filepath.Ext(header.Filename)
-		err, savedMediaPath, savedThumbPath := util.SavePostFile(file, filename)
+		files, err := savePostFiles(db, board, formFiles)
 		if err != nil {
 			http.Error(w, "Failed to save file", http.StatusInternalServerError)
-			log.Printf("savePostFile: %v", err)
+			log.Printf("savePostFiles: %v", err)
 			return
 		}
 
-		threadId, err := database.PutThread(db, slug, subject, body, savedMediaPath, savedThumbPath, ipHash)
+		author, tripcode := util.ParseAuthor(name, tripcodeSecret)
+		threadId, archivedIds, err := database.PutThread(db, slug, subject, database.Post{
+			Author:      author,
+			Tripcode:    tripcode,
+			Body:        body,
+			Files:       files,
+			IpHash:      ipHash,
+			IpRangeHash: ipRangeHash,
+			Spoiler:     r.FormValue("spoiler") == "on",
+		})
 		if err != nil {
+			deletePostFiles(db, files)
+			if errors.Is(err, database.ErrImageLimitReached) {
+				msg := fmt.Sprintf("Threads on this board are limited to %d images", board.ImageLimit)
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
 			http.Error(w, "Failed to create thread", http.StatusInternalServerError)
 			log.Printf("PutThread: %v", err)
 			return
 		}
+		enqueueMediaJobs(db, files)
 
-		util.BeginCooldown(ipHash, util.ThreadCooldowns, util.THREAD_COOLDOWN)
+		for _, archivedId := range archivedIds {
+			util.PublishThreadEvent(util.ThreadEvent{
+				Type:     util.ThreadEventThreadArchived,
+				ThreadId: archivedId,
+			})
+		}
+
+		util.BeginCooldown(ipHash, util.ThreadCooldowns, limits.ThreadCooldown)
 
 		// Check if it's an HTMX request
 		redirectUrl := fmt.Sprintf("/%s/threads/%d", slug, threadId)
+		if options.NoNoko {
+			redirectUrl = "/" + slug
+		}
 		if r.Header.Get("HX-Request") == "true" {
 			w.Header().Set("HX-Redirect", redirectUrl)
 			w.WriteHeader(http.StatusOK)
@@ -298,136 +567,15 @@ func main() {
 	})
 
 	// CREATE POST
-	r.Post("/{slug}/threads/{threadId}", func(w http.ResponseWriter, r *http.Request) {
-		slug := chi.URLParam(r, "slug")
-		ipHash := util.HashIp(util.GetIP(r))
-
-		threadIdStr := chi.URLParam(r, "threadId")
-		threadId, err := strconv.Atoi(threadIdStr)
-		if err != nil {
-			http.Error(w, "Invalid thread id", http.StatusBadRequest)
-			return
-		}
-
-		// guard banned ips
-		ban, err := database.GetBan(db, ipHash)
-		if err != nil {
-			if !errors.Is(err, database.ErrBanNotFound) {
-				http.Error(w, "Failed to get ban", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			msg := fmt.Sprintf("You are banned until: %s. Reason: %s",
-				ban.Expiration.Format("2006-01-02 15:04"),
-				ban.Reason)
-			http.Error(w, msg, http.StatusForbidden)
-			return
-		}
-
-		// check cooldown
-		timeRemaining := util.GetRemainingCooldown(ipHash, util.PostCooldowns, util.POST_COOLDOWN)
-		if timeRemaining > 0 && !isAdmin(r) {
-			response := fmt.Sprintf("Please wait %.0f seconds", timeRemaining.Seconds())
-			io.Copy(io.Discard, r.Body)
-			http.Error(w, response, http.StatusTooManyRequests)
-			return
-		}
-
-		// guard if thread locked
-		isLocked := true
-		row := db.QueryRow(`SELECT locked FROM threads where id = ?`, threadId)
-		if err := row.Scan(&isLocked); err != nil {
-			http.Error(w, "Failed to check if thread locked", http.StatusInternalServerError)
-			return
-		}
-
-		if isLocked && !isAdmin(r) {
-			http.Error(w, "This thread is locked", http.StatusForbidden)
-			return
-		}
-
-		// parse form
-		r.Body = http.MaxBytesReader(w, r.Body, util.MAX_REQUEST_BYTES)
-		if err := r.ParseMultipartForm(util.FILE_MEM_LIMIT); err != nil {
-			var maxErr *http.MaxBytesError
-			if errors.As(err, &maxErr) {
-				http.Error(w, fmt.Sprintf("File too large (max %s)", util.FormatBytes(util.FILE_MEM_LIMIT)), http.StatusRequestEntityTooLarge)
-				return
-			}
-
-			if errors.Is(err, multipart.ErrMessageTooLarge) {
-				http.Error(w, fmt.Sprintf("File too large (max %s)", util.FormatBytes(util.FILE_MEM_LIMIT)), http.StatusRequestEntityTooLarge)
-				return
-			}
-			http.Error(w, "Failed to parse form", http.StatusBadRequest)
-			log.Printf("ParseMultipartForm: %v", err)
-			return
-		}
-
-		// validate inputs
-		body := strings.TrimSpace(r.FormValue("body"))
-		mediaPath := ""
-		thumbPath := ""
-
-		if len(body) > util.MAX_BODY_LEN {
-			http.Error(w, fmt.Sprintf("Body exceeds %d characters", util.MAX_BODY_LEN), http.StatusBadRequest)
-			return
-		}
-
-		fileIsEmpty := false
-		file, header, err := r.FormFile("file")
-		if err != nil {
-			if !errors.Is(err, http.ErrMissingFile) {
-				http.Error(w, "Failed to retrieve file from form", http.StatusBadRequest)
-				log.Printf("FormFile: %v", err)
-				return
-			} else {
-				fileIsEmpty = true
-			}
-		} else {
-			defer file.Close()
-
-			if header.Size > util.FILE_MEM_LIMIT {
-				http.Error(w, fmt.Sprintf("File too large (max %s)", util.FormatBytes(util.FILE_MEM_LIMIT)), http.StatusRequestEntityTooLarge)
-				return
-			}
+	pages.Post("/{slug}/threads/{threadId}", createPostHandler(db, tripcodeSecret, ipRangeKey))
 
-			mediaType, err := util.DetectPostFileType(file)
-			if err != nil {
-				http.Error(w, "Failed to detect if file is a video", http.StatusInternalServerError)
-				return
-			}
-			file.Seek(0, io.SeekStart)
-
-			if mediaType == util.PostFileUnsupported {
-				http.Error(w, "Unsupported media type", http.StatusBadRequest)
-				return
-			}
-
-			filename := strconv.FormatInt(time.Now().UnixNano(), 10) + filepath.Ext(header.Filename)
-			err, savedMediaPath, savedThumbPath := util.SavePostFile(file, filename)
-			if err != nil {
-				http.Error(w, "Failed to save file", http.StatusInternalServerError)
-				log.Printf("savePostFile: %v", err)
-				return
-			}
-			mediaPath = savedMediaPath
-			thumbPath = savedThumbPath
-		}
-
-		if body == "" && fileIsEmpty {
-			http.Error(w, "No body or file provided", http.StatusBadRequest)
-			return
-		}
+	// -----------------
 
-		if err := database.PutPost(db, slug, threadId, body, mediaPath, thumbPath, ipHash); err != nil {
-			http.Error(w, "Failed to create post", http.StatusInternalServerError)
-			log.Printf("PutPost: %v", err)
-			return
-		}
+	// -----------------
+	// API ROUTES (json)
+	// -----------------
 
-		util.BeginCooldown(ipHash, util.PostCooldowns, util.POST_COOLDOWN)
-	})
+	r.Route("/api/v1", apiRoutes(db))
 
 	// -----------------
 
@@ -436,8 +584,19 @@ func main() {
 	// -----------------
 
 	// CATALOG
-	r.Get("/hx/{slug}/catalog", func(w http.ResponseWriter, r *http.Request) {
+	pages.Get("/hx/{slug}/catalog", func(w http.ResponseWriter, r *http.Request) {
 		slug := chi.URLParam(r, "slug")
+		board, err := database.GetBoard(db, slug)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, fmt.Sprintf("Board /%s/ not found", slug), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("GetBoard: %v", err)
+			return
+		}
+
 		threads, err := database.GetThreads(db, slug)
 		if err != nil {
 			http.Error(w, "Failed to get threads", http.StatusInternalServerError)
@@ -455,7 +614,7 @@ func main() {
 			}
 			op := posts[0]
 
-			if op.MediaPath == "" {
+			if len(op.Files) == 0 {
 				http.Error(w, "Malformed thread", http.StatusInternalServerError)
 				log.Printf("Thread %d has no OP image", thread.Id)
 				return
@@ -471,7 +630,8 @@ func main() {
 				Body:       op.Body,
 				ThreadId:   thread.Id,
 				ThreadURL:  fmt.Sprintf("/%s/threads/%d", slug, thread.Id),
-				ThumbPath:  op.ThumbPath,
+				ThumbPath:  op.Files[0].ThumbPath,
+				Spoiler:    op.Spoiler,
 				ReplyCount: len(posts),
 				IpCount:    len(uniqueIpHashes),
 				Pinned:     thread.Pinned,
@@ -481,13 +641,14 @@ func main() {
 		}
 
 		views.ThreadsCatalog(previews, views.CatalogContext{
-			IsAdmin:   isAdmin(r),
-			BoardSlug: slug,
+			Mod:         modPermissions(r, slug),
+			BoardSlug:   slug,
+			SpoilerPath: board.SpoilerPath,
 		}).Render(r.Context(), w)
 	})
 
 	// THREAD POSTS
-	r.Get("/hx/{slug}/threads/{threadId}/posts", func(w http.ResponseWriter, r *http.Request) {
+	pages.Get("/hx/{slug}/threads/{threadId}/posts", func(w http.ResponseWriter, r *http.Request) {
 		// slug := chi.URLParam(r, "slug")
 		threadIdStr := chi.URLParam(r, "threadId")
 		threadId, err := strconv.Atoi(threadIdStr)
@@ -507,6 +668,13 @@ func main() {
 			return
 		}
 
+		board, err := database.GetBoard(db, thread.BoardSlug)
+		if err != nil {
+			http.Error(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("GetBoard: %v", err)
+			return
+		}
+
 		posts, err := database.GetPosts(db, threadId)
 		if err != nil {
 			http.Error(w, "Failed to get posts", http.StatusBadRequest)
@@ -514,16 +682,102 @@ func main() {
 			return
 		}
 
-		if len(posts) == 0 || posts[0].MediaPath == "" {
+		if len(posts) == 0 || (len(posts[0].Files) == 0 && !thread.Archived) {
 			http.Error(w, "Malformed thread", http.StatusInternalServerError)
 			log.Printf("Thread %d has no posts or no OP image", threadId)
 			return
 		}
 
 		// dont pass the op post. only replies
-		views.Posts(posts, thread, views.ThreadContext{
-			IsAdmin: isAdmin(r),
-		}).Render(r.Context(), w)
+		views.Posts(posts, thread, newThreadContext(r, board)).Render(r.Context(), w)
+	})
+
+	// THREAD EVENTS (server-sent events)
+	pages.Get("/hx/{slug}/threads/{threadId}/events", func(w http.ResponseWriter, r *http.Request) {
+		threadIdStr := chi.URLParam(r, "threadId")
+		threadId, err := strconv.Atoi(threadIdStr)
+		if err != nil {
+			http.Error(w, "Invalid thread id", http.StatusBadRequest)
+			return
+		}
+
+		thread, err := database.GetThread(db, threadId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Thread not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get thread", http.StatusInternalServerError)
+			log.Printf("GetThread: %v", err)
+			return
+		}
+
+		board, err := database.GetBoard(db, thread.BoardSlug)
+		if err != nil {
+			http.Error(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("GetBoard: %v", err)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		threadContext := newThreadContext(r, board)
+
+		events, unsubscribe := util.SubscribeThreadEvents(threadId)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(30 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+
+			case event := <-events:
+				var data string
+				switch event.Type {
+				case util.ThreadEventPostCreated:
+					post, err := database.GetPost(db, event.PostId)
+					if err != nil {
+						log.Printf("GetPost: %v", err)
+						continue
+					}
+
+					var buf strings.Builder
+					if err := views.PostReply(post, threadContext).Render(r.Context(), &buf); err != nil {
+						log.Printf("Render PostReply: %v", err)
+						continue
+					}
+					data = buf.String()
+				case util.ThreadEventPostDeleted:
+					data = strconv.Itoa(event.PostNumber)
+				default:
+					data = strconv.Itoa(event.ThreadId)
+				}
+
+				fmt.Fprintf(w, "event: %s\n", event.Type)
+				for _, line := range strings.Split(data, "\n") {
+					fmt.Fprintf(w, "data: %s\n", line)
+				}
+				fmt.Fprint(w, "\n")
+			}
+
+			flusher.Flush()
+		}
 	})
 
 	// -----------------
@@ -532,11 +786,11 @@ func main() {
 	// ADMIN ROUTES (htmx)
 	// -----------------
 
-	r.Get("/authorize", func(w http.ResponseWriter, r *http.Request) {
+	pages.Get("/authorize", func(w http.ResponseWriter, r *http.Request) {
 		admin.AdminLogin().Render(r.Context(), w)
 	})
 
-	r.Post("/authorize", func(w http.ResponseWriter, r *http.Request) {
+	pages.Post("/authorize", func(w http.ResponseWriter, r *http.Request) {
 		username := r.FormValue("username")
 		password := r.FormValue("password")
 
@@ -558,27 +812,36 @@ func main() {
 			return
 		}
 
-		tokenValidUntil := time.Now().Add(time.Hour)
-		util.CreateAdminSession(token, util.AdminSession{
-			Username:   username,
-			Expiration: tokenValidUntil,
-		})
+		timeout := util.GetConfig().AdminSessionTimeout
+		err = database.CreateAdminSession(db, util.HashToken(token), database.AdminSession{
+			Username:  admin.Username,
+			IpHash:    util.HashIp(util.GetIP(r)),
+			UserAgent: r.UserAgent(),
+		}, timeout)
+		if err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			log.Printf("CreateAdminSession: %v", err)
+			return
+		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:     "comfy_admin",
-			Value:    token,
-			HttpOnly: true,
-			Secure:   !util.DevMode,
-			Expires:  tokenValidUntil,
-			SameSite: http.SameSiteStrictMode,
-			Path:     "/",
-		})
+		setAdminSessionCookie(w, token, time.Now().Add(timeout))
 
 	})
 
-	r.Route("/admin", func(r chi.Router) {
+	pages.Route("/invite", staffInviteRoutes(db))
+	pages.Get("/modlog", publicModLogHandler(db))
+	pages.Post("/reports", createReportHandler(db, ipRangeKey))
+
+	pages.Route("/admin", func(r chi.Router) {
 		r.Use(AdminOnlyMiddleware)
 
+		r.With(RequirePermissionMiddleware(util.PermManageBoards)).Route("/boards", adminBoardRoutes(db))
+		r.With(RequirePermissionMiddleware(util.PermBan)).Route("/hashes", adminHashRoutes(db))
+		r.With(RequirePermissionMiddleware(util.PermManageStaff)).Route("/staff", adminStaffRoutes(db))
+		r.With(RequirePermissionMiddleware(util.PermViewModLog)).Get("/modlog", adminModLogHandler(db))
+		r.With(RequirePermissionMiddleware(util.PermDelete)).Route("/reports", adminReportRoutes(db))
+		r.Route("/sessions", adminSessionRoutes(db))
+
 		r.Patch("/threads/{threadId}/lock", func(w http.ResponseWriter, r *http.Request) {
 			threadIdStr := chi.URLParam(r, "threadId")
 			threadId, err := strconv.Atoi(threadIdStr)
@@ -587,6 +850,11 @@ func main() {
 				return
 			}
 
+			thread, ok := checkThreadPermission(w, r, db, util.PermPinLock, threadId)
+			if !ok {
+				return
+			}
+
 			lockedStr := r.URL.Query().Get("locked")
 			locked, err := strconv.ParseBool(lockedStr)
 			if err != nil {
@@ -600,6 +868,21 @@ func main() {
 				http.Error(w, "Failed to lock thread: "+threadIdStr, http.StatusInternalServerError)
 				return
 			}
+
+			action := database.ModActionUnlock
+			if locked {
+				action = database.ModActionLock
+			}
+			logModAction(r, db, database.ModLogEntry{
+				Action:    action,
+				BoardSlug: thread.BoardSlug,
+				ThreadId:  threadId,
+			})
+
+			util.PublishThreadEvent(util.ThreadEvent{
+				Type:     util.ThreadEventThreadUpdated,
+				ThreadId: threadId,
+			})
 		})
 
 		r.Patch("/threads/{threadId}/pin", func(w http.ResponseWriter, r *http.Request) {
@@ -610,6 +893,11 @@ func main() {
 				return
 			}
 
+			thread, ok := checkThreadPermission(w, r, db, util.PermPinLock, threadId)
+			if !ok {
+				return
+			}
+
 			pinnedStr := r.URL.Query().Get("pinned")
 			pinned, err := strconv.ParseBool(pinnedStr)
 			if err != nil {
@@ -623,6 +911,21 @@ func main() {
 				http.Error(w, "Failed to pin thread: "+threadIdStr, http.StatusInternalServerError)
 				return
 			}
+
+			action := database.ModActionUnpin
+			if pinned {
+				action = database.ModActionPin
+			}
+			logModAction(r, db, database.ModLogEntry{
+				Action:    action,
+				BoardSlug: thread.BoardSlug,
+				ThreadId:  threadId,
+			})
+
+			util.PublishThreadEvent(util.ThreadEvent{
+				Type:     util.ThreadEventThreadUpdated,
+				ThreadId: threadId,
+			})
 		})
 
 		r.Delete("/threads/{threadId}", func(w http.ResponseWriter, r *http.Request) {
@@ -633,12 +936,43 @@ func main() {
 				return
 			}
 
+			thread, ok := checkThreadPermission(w, r, db, util.PermDelete, threadId)
+			if !ok {
+				return
+			}
+
+			posts, err := database.GetPosts(db, threadId)
+			if err != nil {
+				log.Println("GetPosts: ", err)
+				http.Error(w, "Failed to get posts: "+threadIdStr, http.StatusInternalServerError)
+				return
+			}
+
 			err = database.DeleteThread(db, threadId)
 			if err != nil {
 				log.Println("DeleteThread: ", err)
 				http.Error(w, "Failed to delete thread: "+threadIdStr, http.StatusInternalServerError)
 				return
 			}
+
+			snapshot := struct {
+				Subject string         `json:"subject"`
+				Posts   []postSnapshot `json:"posts"`
+			}{Subject: thread.Subject}
+			for _, post := range posts {
+				snapshot.Posts = append(snapshot.Posts, newPostSnapshot(post))
+			}
+			logModAction(r, db, database.ModLogEntry{
+				Action:    database.ModActionDeleteThread,
+				BoardSlug: thread.BoardSlug,
+				ThreadId:  threadId,
+				Snapshot:  marshalSnapshot(snapshot),
+			})
+
+			util.PublishThreadEvent(util.ThreadEvent{
+				Type:     util.ThreadEventThreadDeleted,
+				ThreadId: threadId,
+			})
 		})
 
 		r.Delete("/posts/{postId}", func(w http.ResponseWriter, r *http.Request) {
@@ -649,15 +983,43 @@ func main() {
 				return
 			}
 
+			post, err := database.GetPost(db, postId)
+			if err != nil {
+				log.Println("GetPost: ", err)
+				http.Error(w, "Failed to get post: "+postIdStr, http.StatusInternalServerError)
+				return
+			}
+
+			thread, ok := checkThreadPermission(w, r, db, util.PermDelete, post.ThreadId)
+			if !ok {
+				return
+			}
+
 			err = database.DeletePost(db, postId)
 			if err != nil {
 				log.Println("DeletePost: ", err)
 				http.Error(w, "Failed to delete post: "+postIdStr, http.StatusInternalServerError)
 				return
 			}
+
+			logModAction(r, db, database.ModLogEntry{
+				Action:     database.ModActionDeletePost,
+				BoardSlug:  thread.BoardSlug,
+				ThreadId:   thread.Id,
+				PostNumber: post.Number,
+				Snapshot:   marshalSnapshot(newPostSnapshot(post)),
+			})
+
+			util.PublishThreadEvent(util.ThreadEvent{
+				Type:       util.ThreadEventPostDeleted,
+				ThreadId:   post.ThreadId,
+				PostId:     post.Id,
+				PostNumber: post.Number,
+			})
 		})
 
-		// bans the ip stored in the post id
+		// bans the ip stored in the post id, or its whole range when the scope
+		// is "range"
 		r.Post("/ban/{postId}", func(w http.ResponseWriter, r *http.Request) {
 			postIdStr := chi.URLParam(r, "postId")
 			postId, err := strconv.Atoi(postIdStr)
@@ -666,16 +1028,27 @@ func main() {
 				return
 			}
 
-			_, err = db.Exec(`UPDATE posts SET banned = 1 WHERE id = ?`, postId)
+			post, err := database.GetPost(db, postId)
 			if err != nil {
-				http.Error(w, "Failed update post to banned", http.StatusInternalServerError)
+				log.Println("GetPost: ", err)
+				http.Error(w, "Failed to get post: "+postIdStr, http.StatusInternalServerError)
 				return
 			}
 
-			post, err := database.GetPost(db, postId)
+			thread, ok := checkThreadPermission(w, r, db, util.PermBan, post.ThreadId)
+			if !ok {
+				return
+			}
+
+			isRangeBan := r.FormValue("scope") == "range"
+			if isRangeBan && post.IpRangeHash == "" {
+				http.Error(w, "The range of this post's ip was not recorded", http.StatusBadRequest)
+				return
+			}
+
+			_, err = db.Exec(`UPDATE posts SET banned = 1 WHERE id = ?`, postId)
 			if err != nil {
-				log.Println("GetPost: ", err)
-				http.Error(w, "Failed to get post: "+postIdStr, http.StatusInternalServerError)
+				http.Error(w, "Failed update post to banned", http.StatusInternalServerError)
 				return
 			}
 
@@ -689,31 +1062,55 @@ func main() {
 				return
 			}
 
-			err = database.BanIp(db, ipToBan, reason, expiration)
-			if err != nil {
-				log.Println("BanIp: ", err)
-				http.Error(w, "Failed to ban ip: ", http.StatusInternalServerError)
-				return
+			action := database.ModActionBanIp
+			if isRangeBan {
+				action = database.ModActionBanIpRange
+				err = database.BanIpRange(db, post.IpRangeHash, reason, expiration)
+				if err != nil {
+					log.Println("BanIpRange: ", err)
+					http.Error(w, "Failed to ban ip range", http.StatusInternalServerError)
+					return
+				}
+			} else {
+				err = database.BanIp(db, ipToBan, reason, expiration)
+				if err != nil {
+					log.Println("BanIp: ", err)
+					http.Error(w, "Failed to ban ip: ", http.StatusInternalServerError)
+					return
+				}
 			}
+
+			logModAction(r, db, database.ModLogEntry{
+				Action:     action,
+				BoardSlug:  thread.BoardSlug,
+				ThreadId:   thread.Id,
+				PostNumber: post.Number,
+				Reason:     reason,
+				Snapshot: marshalSnapshot(struct {
+					Expiration time.Time    `json:"expiration"`
+					Post       postSnapshot `json:"post"`
+				}{expiration, newPostSnapshot(post)}),
+			})
+
+			util.PublishThreadEvent(util.ThreadEvent{
+				Type:     util.ThreadEventThreadUpdated,
+				ThreadId: post.ThreadId,
+			})
 		})
 
 		r.Post("/logout", func(w http.ResponseWriter, r *http.Request) {
-			if c, err := r.Cookie("comfy_admin"); err == nil {
-				util.DeleteAdminSession(c.Value)
-			}
-			http.SetCookie(w, &http.Cookie{
-				Name:     "comfy_admin",
-				Value:    "",
-				HttpOnly: true,
-				Secure:   !util.DevMode,
-				Expires:  time.Now(),
-				SameSite: http.SameSiteStrictMode,
-				Path:     "/",
-			})
+			if c, err := r.Cookie(util.ADMIN_SESSION_COOKIE); err == nil {
+				if err := database.DeleteAdminSession(db, util.HashToken(c.Value)); err != nil {
+					http.Error(w, "Failed to log out", http.StatusInternalServerError)
+					log.Printf("DeleteAdminSession: %v", err)
+					return
+				}
+			}
+			setAdminSessionCookie(w, "", time.Now())
 		})
 	})
 
-	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+	pages.NotFound(func(w http.ResponseWriter, r *http.Request) {
 		views.NotFound().Render(r.Context(), w)
 	})
 
@@ -729,32 +1126,70 @@ func main() {
 
 		for range ticker.C {
 			// cleanup cooldowns
+			postCooldown, threadCooldown, reportCooldown := util.GetConfig().LongestCooldowns()
 			util.CooldownMutex.Lock()
 			for ip, t := range util.PostCooldowns {
-				if time.Since(t) >= util.POST_COOLDOWN {
+				if time.Since(t) >= postCooldown {
 					delete(util.PostCooldowns, ip)
 				}
 			}
 			for ip, t := range util.ThreadCooldowns {
-				if time.Since(t) >= util.THREAD_COOLDOWN {
+				if time.Since(t) >= threadCooldown {
 					delete(util.ThreadCooldowns, ip)
 				}
 			}
+			for ip, t := range util.ReportCooldowns {
+				if time.Since(t) >= reportCooldown {
+					delete(util.ReportCooldowns, ip)
+				}
+			}
 			util.CooldownMutex.Unlock()
+		}
+	}()
 
-			// cleanup admin sessions
-			util.AdminMutex.Lock()
-			for token, s := range util.AdminSessions {
-				if time.Now().After(s.Expiration) {
-					delete(util.AdminSessions, token)
-				}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for ; true; <-ticker.C {
+			// cleanup archived media
+			purged, err := database.PurgeArchivedMedia(db, util.GetConfig().Media.ArchiveRetention)
+			if err != nil {
+				log.Printf("PurgeArchivedMedia: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged media of %d archived posts", purged)
+			}
+
+			// cleanup old archived threads
+			deleted, err := database.DeleteArchivedThreads(db, util.GetConfig().ArchivedThreadRetention)
+			if err != nil {
+				log.Printf("DeleteArchivedThreads: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d archived threads", deleted)
+			}
+
+			// cleanup finished media jobs
+			pruned, err := database.DeleteFinishedMediaJobs(db, MEDIA_JOB_RETENTION)
+			if err != nil {
+				log.Printf("DeleteFinishedMediaJobs: %v", err)
+			} else if pruned > 0 {
+				log.Printf("Deleted %d finished media jobs", pruned)
+			}
+
+			// cleanup media no post references anymore
+			swept, err := database.SweepReleasedMedia(db, util.RELEASED_MEDIA_GRACE)
+			if err != nil {
+				log.Printf("SweepReleasedMedia: %v", err)
+			} else if swept > 0 {
+				log.Printf("Deleted %d unreferenced media files", swept)
 			}
-			util.AdminMutex.Unlock()
 		}
 	}()
 
 	// -----------------
 
-	fmt.Println("Listening on 0.0.0.0:7676")
-	http.ListenAndServe("0.0.0.0:7676", r)
+	go reloadConfigOnSighup(configPath)
+
+	fmt.Printf("Listening on %s\n", config.Listen)
+	log.Fatal(http.ListenAndServe(config.Listen, r))
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dominicf2001/comfychan/internal/util"
+)
+
+func TestClientIpHashes(t *testing.T) {
+	const key = "secret"
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		wantIp     string
+	}{
+		{"remote addr", "203.0.113.7:4321", "", "203.0.113.7"},
+		{"forwarded", "127.0.0.1:4321", "203.0.113.7, 10.0.0.1", "203.0.113.7"},
+		{"forwarded ipv6", "127.0.0.1:4321", "2001:db8::7", "2001:db8::7"},
+		{"malformed forwarded", "127.0.0.1:4321", "not an ip", ""},
+		{"empty first forwarded", "127.0.0.1:4321", ", 203.0.113.7", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader("body"))
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			w := httptest.NewRecorder()
+
+			ipHash, ipRangeHash, ok := clientIpHashes(w, r, key)
+			if ok != (tt.wantIp != "") {
+				t.Fatalf("clientIpHashes() ok = %v, want %v", ok, tt.wantIp != "")
+			}
+			if !ok {
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("clientIpHashes() status = %d, want %d", w.Code, http.StatusBadRequest)
+				}
+				return
+			}
+			if ipHash != util.HashIp(tt.wantIp) || ipRangeHash != util.HashIpRange(tt.wantIp, key) {
+				t.Errorf("clientIpHashes() = %q, %q, want the hashes of %s", ipHash, ipRangeHash, tt.wantIp)
+			}
+		})
+	}
+}
+
+func TestCreateReportRefusesUnparsableIp(t *testing.T) {
+	db := newTestDB(t)
+
+	form := url.Values{"post": {"1"}, "category": {"spam"}}
+	r := httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Forwarded-For", "unknown")
+	w := httptest.NewRecorder()
+
+	createReportHandler(db, "secret")(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	var reports int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM reports`).Scan(&reports); err != nil {
+		t.Fatal(err)
+	}
+	if reports != 0 {
+		t.Errorf("%d reports saved, want 0", reports)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+)
+
+// a job still running after this is killed and retried
+var MEDIA_JOB_TIMEOUT = 2 * time.Minute
+
+const MAX_MEDIA_JOB_ATTEMPTS = 3
+
+// delay before the nth retry, growing with each attempt
+const MEDIA_JOB_RETRY_DELAY = 30 * time.Second
+
+// finished jobs are kept this long, so failures can be looked into
+const MEDIA_JOB_RETENTION = 7 * 24 * time.Hour
+
+// how often idle workers look for due retries
+const MEDIA_JOB_POLL_INTERVAL = 10 * time.Second
+
+// wakes an idle worker after a job is queued. buffered so queueing never
+// blocks a request
+var mediaJobSignal = make(chan struct{}, 1)
+
+// queues processing of the pending files of a post that was just created
+func enqueueMediaJobs(db *sql.DB, files []database.PostFile) {
+	queued := map[string]bool{}
+	for _, f := range files {
+		if f.MediaStatus != database.MediaStatusPending || queued[f.MediaPath] {
+			continue
+		}
+		queued[f.MediaPath] = true
+
+		if err := database.EnqueueMediaJob(db, f.MediaPath, f.MetadataStripped); err != nil {
+			log.Printf("EnqueueMediaJob: %v", err)
+		}
+	}
+
+	if len(queued) > 0 {
+		select {
+		case mediaJobSignal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// starts the workers processing queued media jobs. jobs interrupted by a
+// restart run again
+func startMediaWorkers(db *sql.DB) {
+	reset, err := database.ResetRunningMediaJobs(db)
+	if err != nil {
+		log.Printf("ResetRunningMediaJobs: %v", err)
+	} else if reset > 0 {
+		log.Printf("Requeued %d interrupted media jobs", reset)
+	}
+
+	// the media.workers setting bounds how many ffmpeg processes run at once
+	for range util.GetConfig().Media.Workers {
+		go mediaWorker(db)
+	}
+}
+
+func mediaWorker(db *sql.DB) {
+	ticker := time.NewTicker(MEDIA_JOB_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		job, err := database.ClaimMediaJob(db)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("ClaimMediaJob: %v", err)
+			}
+			select {
+			case <-mediaJobSignal:
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		runMediaJob(db, job)
+	}
+}
+
+func runMediaJob(db *sql.DB, job database.MediaJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), MEDIA_JOB_TIMEOUT)
+	defer cancel()
+
+	opts := util.UploadOptions{StripMetadata: job.StripMetadata}
+	thumbPath, previewPath, info, err := util.ProcessPendingFile(ctx, job.MediaPath, opts)
+
+	// the post was deleted before the job ran
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := database.SkipMediaJob(db, job.Id); err != nil {
+			log.Printf("SkipMediaJob: %v", err)
+		}
+		return
+	}
+
+	if err != nil {
+		attempt := job.Attempts + 1
+		log.Printf("Media job %d (%s) attempt %d failed: %v", job.Id, job.MediaPath, attempt, err)
+
+		if attempt >= MAX_MEDIA_JOB_ATTEMPTS {
+			if err := database.FailMediaJob(db, job, err); err != nil {
+				log.Printf("FailMediaJob: %v", err)
+			}
+			return
+		}
+
+		delay := time.Duration(attempt) * MEDIA_JOB_RETRY_DELAY
+		if err := database.RetryMediaJob(db, job.Id, err, delay); err != nil {
+			log.Printf("RetryMediaJob: %v", err)
+		}
+		return
+	}
+
+	if err := database.CompleteMediaJob(db, job, thumbPath, previewPath, info); err != nil {
+		log.Printf("CompleteMediaJob: %v", err)
+		return
+	}
+
+	// refresh open threads so the placeholder is replaced
+	threadIds, err := database.GetThreadIdsWithMedia(db, job.MediaPath)
+	if err != nil {
+		log.Printf("GetThreadIdsWithMedia: %v", err)
+		return
+	}
+	for _, threadId := range threadIds {
+		util.PublishThreadEvent(util.ThreadEvent{
+			Type:     util.ThreadEventThreadUpdated,
+			ThreadId: threadId,
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/dominicf2001/comfychan/web/views"
+	"github.com/dominicf2001/comfychan/web/views/admin"
+)
+
+const MOD_LOG_PAGE_SIZE = 50
+
+// what the mod log keeps of a deleted or banned post. it is kept forever, so
+// it leaves out the poster's ip hashes
+type postSnapshot struct {
+	Number    int            `json:"number"`
+	Author    string         `json:"author"`
+	Tripcode  string         `json:"tripcode,omitempty"`
+	Body      string         `json:"body"`
+	CreatedAt time.Time      `json:"created_at"`
+	Files     []fileSnapshot `json:"files,omitempty"`
+}
+
+type fileSnapshot struct {
+	Name   string `json:"name,omitempty"`
+	Sha256 string `json:"sha256"`
+}
+
+func newPostSnapshot(post database.Post) postSnapshot {
+	s := postSnapshot{
+		Number:    post.Number,
+		Author:    post.Author,
+		Tripcode:  post.Tripcode,
+		Body:      post.Body,
+		CreatedAt: post.CreatedAt,
+	}
+	for _, file := range post.Files {
+		s.Files = append(s.Files, fileSnapshot{
+			Name:   cmp.Or(file.OriginalName, file.MediaPath),
+			Sha256: file.Sha256,
+		})
+	}
+	return s
+}
+
+func marshalSnapshot(v any) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Printf("marshalSnapshot: %v", err)
+		return ""
+	}
+	return string(data)
+}
+
+// records a moderation action taken by the staff member making the request.
+// the action already happened, so failing to record it is only logged
+func logModAction(r *http.Request, db *sql.DB, entry database.ModLogEntry) {
+	admin, _ := getAdmin(r)
+	entry.Actor = admin.Username
+	if err := database.AddModLogEntry(db, entry); err != nil {
+		log.Printf("AddModLogEntry: %v", err)
+	}
+}
+
+func parseModLogFilter(r *http.Request) database.ModLogFilter {
+	query := r.URL.Query()
+	filter := database.ModLogFilter{
+		Actor:     query.Get("actor"),
+		Action:    query.Get("action"),
+		BoardSlug: query.Get("board"),
+		Limit:     MOD_LOG_PAGE_SIZE,
+	}
+	if !slices.Contains(database.MOD_ACTIONS, filter.Action) {
+		filter.Action = ""
+	}
+	filter.BeforeId, _ = strconv.Atoi(query.Get("before"))
+	return filter
+}
+
+func adminModLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := parseModLogFilter(r)
+		entries, err := database.GetModLog(db, filter)
+		if err != nil {
+			http.Error(w, "Failed to get mod log", http.StatusInternalServerError)
+			log.Printf("GetModLog: %v", err)
+			return
+		}
+
+		boards, err := database.GetBoards(db)
+		if err != nil {
+			http.Error(w, "Failed to get boards", http.StatusInternalServerError)
+			log.Printf("GetBoards: %v", err)
+			return
+		}
+
+		admin.AdminModLog(entries, filter, boards).Render(r.Context(), w)
+	}
+}
+
+// the anonymized log anyone can read when public_mod_log is on. actors and
+// snapshots are left out
+func publicModLogHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !util.GetConfig().PublicModLog {
+			w.WriteHeader(http.StatusNotFound)
+			views.NotFound().Render(r.Context(), w)
+			return
+		}
+
+		filter := parseModLogFilter(r)
+		filter.Actor = ""
+		entries, err := database.GetModLog(db, filter)
+		if err != nil {
+			http.Error(w, "Failed to get mod log", http.StatusInternalServerError)
+			log.Printf("GetModLog: %v", err)
+			return
+		}
+
+		for i := range entries {
+			entries[i].Actor = ""
+			entries[i].Snapshot = ""
+		}
+
+		boards, err := database.GetBoards(db)
+		if err != nil {
+			http.Error(w, "Failed to get boards", http.StatusInternalServerError)
+			log.Printf("GetBoards: %v", err)
+			return
+		}
+
+		views.ModLog(entries, filter, boards).Render(r.Context(), w)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// replies to the thread in the URL. the thread must be on the board in the
+// URL, since that board's limits and settings apply to the reply
+func createPostHandler(db *sql.DB, tripcodeSecret, ipRangeKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		slug := chi.URLParam(r, "slug")
+		ipHash, ipRangeHash, ok := clientIpHashes(w, r, ipRangeKey)
+		if !ok {
+			return
+		}
+
+		threadIdStr := chi.URLParam(r, "threadId")
+		threadId, err := strconv.Atoi(threadIdStr)
+		if err != nil {
+			http.Error(w, "Invalid thread id", http.StatusBadRequest)
+			return
+		}
+
+		// a thread is only reachable through its own board, whose limits,
+		// numbering and upload settings apply to the reply
+		isLocked, isArchived := true, true
+		var threadBoardSlug string
+		row := db.QueryRow(`SELECT locked, archived, board_slug FROM threads where id = ?`, threadId)
+		if err := row.Scan(&isLocked, &isArchived, &threadBoardSlug); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Thread not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get thread", http.StatusInternalServerError)
+			log.Printf("Failed to get thread %d: %v", threadId, err)
+			return
+		}
+		if threadBoardSlug != slug {
+			http.Error(w, "Thread not found", http.StatusNotFound)
+			return
+		}
+
+		limits := util.GetConfig().BoardLimits(slug)
+
+		// guard banned ips and ranges
+		ban, err := database.GetBan(db, ipHash)
+		if errors.Is(err, database.ErrBanNotFound) {
+			ban, err = database.GetRangeBan(db, ipRangeHash)
+		}
+		if err != nil {
+			if !errors.Is(err, database.ErrBanNotFound) {
+				http.Error(w, "Failed to get ban", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			msg := fmt.Sprintf("You are banned until: %s. Reason: %s",
+				ban.Expiration.Format("2006-01-02 15:04"),
+				ban.Reason)
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+
+		// check cooldown
+		timeRemaining := util.GetRemainingCooldown(ipHash, util.PostCooldowns, limits.PostCooldown)
+		if timeRemaining > 0 && !moderatesBoard(r, slug) {
+			response := fmt.Sprintf("Please wait %.0f seconds", timeRemaining.Seconds())
+			io.Copy(io.Discard, r.Body)
+			http.Error(w, response, http.StatusTooManyRequests)
+			return
+		}
+
+		// guard if thread locked or archived
+		if isArchived {
+			http.Error(w, "This thread is archived", http.StatusForbidden)
+			return
+		}
+
+		if isLocked && !hasPermission(r, util.PermPinLock, threadBoardSlug) {
+			http.Error(w, "This thread is locked", http.StatusForbidden)
+			return
+		}
+
+		// parse form
+		r.Body = http.MaxBytesReader(w, r.Body, limits.MaxRequestBytes())
+		if err := r.ParseMultipartForm(int64(limits.MaxFileSize)); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, fmt.Sprintf("File too large (max %s)", limits.MaxFileSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			if errors.Is(err, multipart.ErrMessageTooLarge) {
+				http.Error(w, fmt.Sprintf("File too large (max %s)", limits.MaxFileSize), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Failed to parse form", http.StatusBadRequest)
+			log.Printf("ParseMultipartForm: %v", err)
+			return
+		}
+
+		// validate inputs
+		name := strings.TrimSpace(r.FormValue("name"))
+		optionsStr := strings.TrimSpace(r.FormValue("options"))
+		body := strings.TrimSpace(r.FormValue("body"))
+
+		if len(name) > util.MAX_NAME_LEN {
+			http.Error(w, fmt.Sprintf("Name exceeds %d characters", util.MAX_NAME_LEN), http.StatusBadRequest)
+			return
+		}
+
+		if len(optionsStr) > util.MAX_OPTIONS_LEN {
+			http.Error(w, fmt.Sprintf("Options exceed %d characters", util.MAX_OPTIONS_LEN), http.StatusBadRequest)
+			return
+		}
+		options := util.ParsePostOptions(optionsStr)
+
+		if len(body) > limits.MaxBodyLen {
+			http.Error(w, fmt.Sprintf("Body exceeds %d characters", limits.MaxBodyLen), http.StatusBadRequest)
+			return
+		}
+
+		// the thread's board decides which files are accepted and how they
+		// are processed
+		board, err := database.GetBoard(db, threadBoardSlug)
+		if err != nil {
+			http.Error(w, "Failed to get board", http.StatusInternalServerError)
+			log.Printf("GetBoard: %v", err)
+			return
+		}
+
+		formFiles, code, err := postFormFiles(r, db, board)
+		if err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		if body == "" && len(formFiles) == 0 {
+			http.Error(w, "No body or file provided", http.StatusBadRequest)
+			return
+		}
+
+		var files []database.PostFile
+		if len(formFiles) > 0 {
+			files, err = savePostFiles(db, board, formFiles)
+			if err != nil {
+				http.Error(w, "Failed to save file", http.StatusInternalServerError)
+				log.Printf("savePostFiles: %v", err)
+				return
+			}
+		}
+
+		author, tripcode := util.ParseAuthor(name, tripcodeSecret)
+		// the image limit is checked along with the insert
+		postId, err := database.PutReply(db, slug, database.Post{
+			ThreadId:    threadId,
+			Author:      author,
+			Tripcode:    tripcode,
+			Body:        body,
+			Files:       files,
+			IpHash:      ipHash,
+			IpRangeHash: ipRangeHash,
+			Sage:        options.Sage,
+			Spoiler:     r.FormValue("spoiler") == "on" && len(files) > 0,
+		})
+		if err != nil {
+			deletePostFiles(db, files)
+			if errors.Is(err, database.ErrImageLimitReached) {
+				msg := fmt.Sprintf("Image limit reached (%d images). You can still reply without a file", board.ImageLimit)
+				http.Error(w, msg, http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to create post", http.StatusInternalServerError)
+			log.Printf("PutReply: %v", err)
+			return
+		}
+		enqueueMediaJobs(db, files)
+
+		util.PublishThreadEvent(util.ThreadEvent{
+			Type:     util.ThreadEventPostCreated,
+			ThreadId: threadId,
+			PostId:   postId,
+		})
+
+		util.BeginCooldown(ipHash, util.PostCooldowns, limits.PostCooldown)
+
+		if options.NoNoko {
+			redirectUrl := "/" + slug
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Redirect", redirectUrl)
+				w.WriteHeader(http.StatusOK)
+			} else {
+				http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+			}
+		}
+	}
+}
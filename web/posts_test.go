@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/go-chi/chi/v5"
+)
+
+// a multipart reply with body and, when fileName is set, a file
+func replyRequest(t *testing.T, url, remoteAddr, body, fileName string, file []byte) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField("body", body)
+	if fileName != "" {
+		part, err := form.CreateFormFile("file", fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file)
+	}
+	form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, url, &buf)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.RemoteAddr = remoteAddr
+	return r
+}
+
+func TestCreatePostThroughAnotherBoard(t *testing.T) {
+	db := newTestDB(t)
+	router := chi.NewRouter()
+	router.Post("/{slug}/threads/{threadId}", createPostHandler(db, "", "secret"))
+
+	threadId, _, err := database.PutThread(db, "c", "comfy", database.Post{Body: "op"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gives /r/ a higher post number sequence than /c/
+	for range 3 {
+		if _, _, err := database.PutThread(db, "r", "robots", database.Post{Body: "op"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+	}{
+		{"other board", "/r/threads/" + strconv.Itoa(threadId), http.StatusNotFound},
+		{"missing board", "/nope/threads/" + strconv.Itoa(threadId), http.StatusNotFound},
+		{"missing thread", "/c/threads/9999", http.StatusNotFound},
+		{"own board", "/c/threads/" + strconv.Itoa(threadId), http.StatusOK},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, replyRequest(t, tt.url, "203.0.113."+strconv.Itoa(i+1)+":1234", "reply", "", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d (%s), want %d", w.Code, w.Body, tt.wantStatus)
+			}
+		})
+	}
+
+	posts, err := database.GetPosts(db, threadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 {
+		t.Fatalf("thread has %d posts, want the op and one reply", len(posts))
+	}
+	// numbered in /c/'s sequence
+	if posts[1].Number != 2 {
+		t.Errorf("reply number = %d, want 2", posts[1].Number)
+	}
+}
+
+func TestCreatePostFileTypesOfThreadBoard(t *testing.T) {
+	db := newTestDB(t)
+	router := chi.NewRouter()
+	router.Post("/{slug}/threads/{threadId}", createPostHandler(db, "", "secret"))
+
+	if _, err := db.Exec(`UPDATE boards SET allowed_media_types = 'image,video,audio,pdf' WHERE slug = 'r'`); err != nil {
+		t.Fatal(err)
+	}
+	threadId, _, err := database.PutThread(db, "c", "images only", database.Post{Body: "op"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n")
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"through a board accepting pdfs", "/r/threads/" + strconv.Itoa(threadId), http.StatusNotFound, "Thread not found"},
+		{"through its own board", "/c/threads/" + strconv.Itoa(threadId), http.StatusBadRequest, "/c/ does not accept pdf files"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, replyRequest(t, tt.url, "198.51.100."+strconv.Itoa(i+1)+":1234", "", "doc.pdf", pdf))
+			if w.Code != tt.wantStatus || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("response = %d %q, want %d %q", w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	stats, err := database.GetThreadStats(db, threadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.ReplyCount != 0 {
+		t.Errorf("thread has %d replies, want 0", stats.ReplyCount)
+	}
+}
+
+// a small jpeg with an EXIF segment holding a GPS position
+func gpsJpeg(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+	plain := buf.Bytes()
+
+	// big endian TIFF with a GPS IFD holding GPSLatitudeRef "N"
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08" +
+		"\x00\x01" + "\x88\x25\x00\x04\x00\x00\x00\x01\x00\x00\x00\x1a" + "\x00\x00\x00\x00" +
+		"\x00\x01" + "\x00\x01\x00\x02\x00\x00\x00\x02N\x00\x00\x00" + "\x00\x00\x00\x00")
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+
+	data := bytes.Clone(plain[:2])
+	data = append(data, segment...)
+	data = append(data, payload...)
+	return append(data, plain[2:]...)
+}
+
+func TestCreatePostStripsMetadataForThreadBoard(t *testing.T) {
+	db := newTestDB(t)
+	previous := util.MEDIA_STORE
+	util.MEDIA_STORE = &util.LocalMediaStore{Root: t.TempDir(), URLPrefix: "/media"}
+	t.Cleanup(func() { util.MEDIA_STORE = previous })
+
+	router := chi.NewRouter()
+	router.Post("/{slug}/threads/{threadId}", createPostHandler(db, "", "secret"))
+
+	if _, err := db.Exec(`UPDATE boards SET strip_metadata = (slug = 'c')`); err != nil {
+		t.Fatal(err)
+	}
+	threadId, _, err := database.PutThread(db, "c", "stripped", database.Post{Body: "op"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo := gpsJpeg(t)
+	if !bytes.Contains(photo, []byte("Exif")) {
+		t.Fatal("test photo has no EXIF")
+	}
+
+	// /r/ keeps metadata, but can't be used to reach the thread
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, replyRequest(t, "/r/threads/"+strconv.Itoa(threadId), "192.0.2.1:1234", "", "photo.jpg", photo))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("reply through /r/ = %d %q, want %d", w.Code, w.Body, http.StatusNotFound)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, replyRequest(t, "/c/threads/"+strconv.Itoa(threadId), "192.0.2.2:1234", "", "photo.jpg", photo))
+	if w.Code != http.StatusOK {
+		t.Fatalf("reply through /c/ = %d %q, want %d", w.Code, w.Body, http.StatusOK)
+	}
+
+	posts, err := database.GetPosts(db, threadId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || len(posts[1].Files) != 1 {
+		t.Fatalf("got %d posts, want the op and a reply with one file", len(posts))
+	}
+	file := posts[1].Files[0]
+	if !file.MetadataStripped {
+		t.Error("reply file is not marked as stripped")
+	}
+
+	object, err := util.MEDIA_STORE.Open(util.PostMediaKey(file.MediaPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	stored, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("Exif")) {
+		t.Error("stored file still has its EXIF segment")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/dominicf2001/comfychan/web/views/admin"
+	"github.com/go-chi/chi/v5"
+)
+
+// what the mod log keeps of dismissed reports
+type reportSnapshot struct {
+	Category  string    `json:"category"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// reports the post named by the form. an ip reports once per report cooldown
+func createReportHandler(db *sql.DB, ipRangeKey string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ipHash, ipRangeHash, ok := clientIpHashes(w, r, ipRangeKey)
+		if !ok {
+			return
+		}
+
+		postId, err := strconv.Atoi(r.FormValue("post"))
+		if err != nil {
+			http.Error(w, "Invalid post id", http.StatusBadRequest)
+			return
+		}
+
+		category := r.FormValue("category")
+		if !slices.Contains(database.REPORT_CATEGORIES, category) {
+			http.Error(w, "Pick a reason for the report", http.StatusBadRequest)
+			return
+		}
+
+		comment := strings.TrimSpace(r.FormValue("comment"))
+		if len(comment) > util.MAX_REPORT_COMMENT_LEN {
+			http.Error(w, fmt.Sprintf("Comment exceeds %d characters", util.MAX_REPORT_COMMENT_LEN), http.StatusBadRequest)
+			return
+		}
+
+		// guard banned ips and ranges
+		ban, err := database.GetBan(db, ipHash)
+		if errors.Is(err, database.ErrBanNotFound) {
+			ban, err = database.GetRangeBan(db, ipRangeHash)
+		}
+		if err != nil {
+			if !errors.Is(err, database.ErrBanNotFound) {
+				http.Error(w, "Failed to get ban", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			msg := fmt.Sprintf("You are banned until: %s. Reason: %s",
+				ban.Expiration.Format("2006-01-02 15:04"),
+				ban.Reason)
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+
+		post, err := database.GetPost(db, postId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get post", http.StatusInternalServerError)
+			log.Printf("GetPost: %v", err)
+			return
+		}
+
+		thread, err := database.GetThread(db, post.ThreadId)
+		if err != nil {
+			http.Error(w, "Failed to get thread", http.StatusInternalServerError)
+			log.Printf("GetThread: %v", err)
+			return
+		}
+
+		// check cooldown
+		limits := util.GetConfig().BoardLimits(thread.BoardSlug)
+		timeRemaining := util.GetRemainingCooldown(ipHash, util.ReportCooldowns, limits.ReportCooldown)
+		if timeRemaining > 0 {
+			response := fmt.Sprintf("Please wait %.0f seconds before reporting again", timeRemaining.Seconds())
+			http.Error(w, response, http.StatusTooManyRequests)
+			return
+		}
+
+		err = database.PutReport(db, database.Report{
+			PostId:   post.Id,
+			Category: category,
+			Comment:  comment,
+			IpHash:   ipHash,
+		})
+		if err != nil {
+			http.Error(w, "Failed to report post", http.StatusInternalServerError)
+			log.Printf("PutReport: %v", err)
+			return
+		}
+
+		util.BeginCooldown(ipHash, util.ReportCooldowns, limits.ReportCooldown)
+	}
+}
+
+// the moderation queue. its delete and ban buttons use the post routes of
+// /admin, and deleted posts take their reports with them
+func adminReportRoutes(db *sql.DB) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			reported, err := database.GetReportedPosts(db)
+			if err != nil {
+				http.Error(w, "Failed to get reports", http.StatusInternalServerError)
+				log.Printf("GetReportedPosts: %v", err)
+				return
+			}
+
+			var items []admin.ReportQueueItem
+			for _, rp := range reported {
+				if !moderatesBoard(r, rp.BoardSlug) {
+					continue
+				}
+				items = append(items, admin.ReportQueueItem{
+					ReportedPost: rp,
+					Mod:          modPermissions(r, rp.BoardSlug),
+				})
+			}
+
+			admin.AdminReports(items).Render(r.Context(), w)
+		})
+
+		r.Delete("/{postId}", func(w http.ResponseWriter, r *http.Request) {
+			postIdStr := chi.URLParam(r, "postId")
+			postId, err := strconv.Atoi(postIdStr)
+			if err != nil {
+				http.Error(w, "Invalid post id", http.StatusBadRequest)
+				return
+			}
+
+			post, err := database.GetPost(db, postId)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "Post not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Failed to get post", http.StatusInternalServerError)
+				log.Printf("GetPost: %v", err)
+				return
+			}
+
+			thread, ok := checkThreadPermission(w, r, db, util.PermDelete, post.ThreadId)
+			if !ok {
+				return
+			}
+
+			reports, err := database.GetReports(db, postId)
+			if err != nil {
+				http.Error(w, "Failed to get reports", http.StatusInternalServerError)
+				log.Printf("GetReports: %v", err)
+				return
+			}
+			if len(reports) == 0 {
+				return
+			}
+
+			if err := database.DeleteReports(db, postId); err != nil {
+				http.Error(w, "Failed to dismiss reports", http.StatusInternalServerError)
+				log.Printf("DeleteReports: %v", err)
+				return
+			}
+
+			var snapshot []reportSnapshot
+			for _, report := range reports {
+				snapshot = append(snapshot, reportSnapshot{
+					Category:  report.Category,
+					Comment:   report.Comment,
+					CreatedAt: report.CreatedAt,
+				})
+			}
+			logModAction(r, db, database.ModLogEntry{
+				Action:     database.ModActionDismissReports,
+				BoardSlug:  thread.BoardSlug,
+				ThreadId:   thread.Id,
+				PostNumber: post.Number,
+				Snapshot:   marshalSnapshot(snapshot),
+			})
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/dominicf2001/comfychan/web/views/admin"
+	"github.com/go-chi/chi/v5"
+)
+
+type adminContextKey struct{}
+
+type adminContext struct {
+	Session database.AdminSession
+	Admin   database.Admin
+}
+
+// looks up the admin session of the request's cookie and its admin once, so
+// handlers can check them without going to the database. sessions in use have
+// their expiry and cookie pushed forward
+func adminSessionMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := r.Cookie(util.ADMIN_SESSION_COOKIE)
+			if err != nil || c.Value == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			session, err := database.GetAdminSession(db, util.HashToken(c.Value))
+			if err != nil {
+				if !errors.Is(err, database.ErrAdminSessionNotFound) {
+					log.Printf("GetAdminSession: %v", err)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// the admin may have been removed from the staff since logging in
+			admin, err := database.GetAdmin(db, session.Username)
+			if err != nil {
+				if !errors.Is(err, database.ErrAdminNotFound) {
+					log.Printf("GetAdmin: %v", err)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			timeout := util.GetConfig().AdminSessionTimeout
+			touched, err := database.TouchAdminSession(db, session.Id, timeout)
+			if err != nil {
+				log.Printf("TouchAdminSession: %v", err)
+			} else if touched {
+				setAdminSessionCookie(w, c.Value, time.Now().Add(timeout))
+			}
+
+			ctx := context.WithValue(r.Context(), adminContextKey{}, adminContext{
+				Session: session,
+				Admin:   admin,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// the session of the admin making the request, if they are logged in
+func getAdminSession(r *http.Request) (database.AdminSession, bool) {
+	c, ok := r.Context().Value(adminContextKey{}).(adminContext)
+	return c.Session, ok
+}
+
+// the staff member making the request, if they are logged in. in dev mode
+// everyone is an owner
+func getAdmin(r *http.Request) (database.Admin, bool) {
+	c, ok := r.Context().Value(adminContextKey{}).(adminContext)
+	if !ok && util.GetConfig().DevMode {
+		return database.Admin{Role: util.RoleOwner}, true
+	}
+	return c.Admin, ok
+}
+
+// an empty token with an expiry in the past clears the cookie
+func setAdminSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     util.ADMIN_SESSION_COOKIE,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   !util.GetConfig().DevMode,
+		Expires:  expires,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+}
+
+func adminSessionRoutes(db *sql.DB) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			current, _ := getAdminSession(r)
+			sessions, err := database.GetAdminSessions(db, current.Username)
+			if err != nil {
+				http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
+				log.Printf("GetAdminSessions: %v", err)
+				return
+			}
+
+			admin.AdminSessions(sessions, current.Id).Render(r.Context(), w)
+		})
+
+		// logs out every other device of the admin
+		r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+			current, ok := getAdminSession(r)
+			if !ok {
+				http.Error(w, "Not logged in", http.StatusBadRequest)
+				return
+			}
+
+			if err := database.RevokeOtherAdminSessions(db, current.Username, current.Id); err != nil {
+				http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+				log.Printf("RevokeOtherAdminSessions: %v", err)
+				return
+			}
+		})
+
+		r.Delete("/{sessionId}", func(w http.ResponseWriter, r *http.Request) {
+			sessionIdStr := chi.URLParam(r, "sessionId")
+			sessionId, err := strconv.Atoi(sessionIdStr)
+			if err != nil {
+				http.Error(w, "Invalid session id", http.StatusBadRequest)
+				return
+			}
+
+			current, ok := getAdminSession(r)
+			if !ok {
+				http.Error(w, "Not logged in", http.StatusBadRequest)
+				return
+			}
+
+			if err := database.RevokeAdminSession(db, current.Username, sessionId); err != nil {
+				http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+				log.Printf("RevokeAdminSession: %v", err)
+				return
+			}
+		})
+	}
+}
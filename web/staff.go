@@ -0,0 +1,319 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+	"github.com/dominicf2001/comfychan/web/views"
+	"github.com/dominicf2001/comfychan/web/views/admin"
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// how long an invite link can be used
+const STAFF_INVITE_TTL = 7 * 24 * time.Hour
+
+// parses the role and boards of the invite and staff edit forms. the returned
+// error is meant for the user
+func parseStaffForm(r *http.Request, db *sql.DB) (util.StaffRole, []string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", nil, errors.New("Failed to parse form")
+	}
+
+	role, err := util.ParseStaffRole(r.FormValue("role"))
+	if err != nil {
+		return "", nil, err
+	}
+
+	if !role.IsBoardScoped() {
+		return role, nil, nil
+	}
+
+	boardSlugs := r.Form["boards"]
+	if len(boardSlugs) == 0 {
+		return "", nil, fmt.Errorf("Pick the boards the %s role applies to", role.Label())
+	}
+
+	boards, err := database.GetBoards(db)
+	if err != nil {
+		log.Printf("GetBoards: %v", err)
+		return "", nil, errors.New("Failed to get boards")
+	}
+	for _, slug := range boardSlugs {
+		if !slices.ContainsFunc(boards, func(b database.Board) bool { return b.Slug == slug }) {
+			return "", nil, fmt.Errorf("Board /%s/ not found", slug)
+		}
+	}
+
+	return role, boardSlugs, nil
+}
+
+// checks that the current admin may give target newRole, or remove them when
+// newRole is empty. the returned error is meant for the user, along with the
+// status to send it with
+func checkCanManageStaff(db *sql.DB, current database.Admin, target database.Admin, newRole util.StaffRole) (int, error) {
+	if current.Id == target.Id {
+		return http.StatusBadRequest, errors.New("You can't change your own account")
+	}
+	if !current.Role.CanManage(target.Role) {
+		return http.StatusForbidden, fmt.Errorf("You are not allowed to manage staff with the %s role", target.Role.Label())
+	}
+	if newRole != "" && !current.Role.CanManage(newRole) {
+		return http.StatusForbidden, fmt.Errorf("You are not allowed to give out the %s role", newRole.Label())
+	}
+
+	if target.Role == util.RoleOwner && newRole != util.RoleOwner {
+		owners, err := database.CountAdminsWithRole(db, util.RoleOwner)
+		if err != nil {
+			log.Printf("CountAdminsWithRole: %v", err)
+			return http.StatusInternalServerError, errors.New("Failed to count owners")
+		}
+		if owners <= 1 {
+			return http.StatusBadRequest, errors.New("The last owner can't be changed")
+		}
+	}
+	return 0, nil
+}
+
+func getStaffFromURL(w http.ResponseWriter, r *http.Request, db *sql.DB) (database.Admin, bool) {
+	adminIdStr := chi.URLParam(r, "adminId")
+	adminId, err := strconv.Atoi(adminIdStr)
+	if err != nil {
+		http.Error(w, "Invalid staff id", http.StatusBadRequest)
+		return database.Admin{}, false
+	}
+
+	target, err := database.GetAdminById(db, adminId)
+	if err != nil {
+		if errors.Is(err, database.ErrAdminNotFound) {
+			http.Error(w, "Staff member not found", http.StatusNotFound)
+			return database.Admin{}, false
+		}
+		http.Error(w, "Failed to get staff member", http.StatusInternalServerError)
+		log.Printf("GetAdminById: %v", err)
+		return database.Admin{}, false
+	}
+
+	return target, true
+}
+
+func adminStaffRoutes(db *sql.DB) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			current, _ := getAdmin(r)
+
+			staff, err := database.GetAdmins(db)
+			if err != nil {
+				http.Error(w, "Failed to get staff", http.StatusInternalServerError)
+				log.Printf("GetAdmins: %v", err)
+				return
+			}
+
+			invites, err := database.GetStaffInvites(db)
+			if err != nil {
+				http.Error(w, "Failed to get invites", http.StatusInternalServerError)
+				log.Printf("GetStaffInvites: %v", err)
+				return
+			}
+
+			boards, err := database.GetBoards(db)
+			if err != nil {
+				http.Error(w, "Failed to get boards", http.StatusInternalServerError)
+				log.Printf("GetBoards: %v", err)
+				return
+			}
+
+			admin.AdminStaff(current, staff, invites, boards).Render(r.Context(), w)
+		})
+
+		// responds with the path of the invite link, which is only shown once
+		r.Post("/invites", func(w http.ResponseWriter, r *http.Request) {
+			current, _ := getAdmin(r)
+
+			role, boards, err := parseStaffForm(r, db)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if !current.Role.CanManage(role) {
+				http.Error(w, fmt.Sprintf("You are not allowed to give out the %s role", role.Label()), http.StatusForbidden)
+				return
+			}
+
+			token, err := util.GenToken()
+			if err != nil {
+				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+				return
+			}
+
+			err = database.CreateStaffInvite(db, util.HashToken(token), database.StaffInvite{
+				Role:      role,
+				Boards:    boards,
+				CreatedBy: current.Username,
+			}, STAFF_INVITE_TTL)
+			if err != nil {
+				http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+				log.Printf("CreateStaffInvite: %v", err)
+				return
+			}
+
+			fmt.Fprintf(w, "/invite/%s", token)
+		})
+
+		r.Delete("/invites/{inviteId}", func(w http.ResponseWriter, r *http.Request) {
+			inviteIdStr := chi.URLParam(r, "inviteId")
+			inviteId, err := strconv.Atoi(inviteIdStr)
+			if err != nil {
+				http.Error(w, "Invalid invite id", http.StatusBadRequest)
+				return
+			}
+
+			invites, err := database.GetStaffInvites(db)
+			if err != nil {
+				http.Error(w, "Failed to get invites", http.StatusInternalServerError)
+				log.Printf("GetStaffInvites: %v", err)
+				return
+			}
+
+			i := slices.IndexFunc(invites, func(i database.StaffInvite) bool { return i.Id == inviteId })
+			if i < 0 {
+				http.Error(w, "Invite not found", http.StatusNotFound)
+				return
+			}
+
+			current, _ := getAdmin(r)
+			if !current.Role.CanManage(invites[i].Role) {
+				http.Error(w, fmt.Sprintf("You are not allowed to manage staff with the %s role", invites[i].Role.Label()), http.StatusForbidden)
+				return
+			}
+
+			if err := database.DeleteStaffInvite(db, inviteId); err != nil {
+				http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+				log.Printf("DeleteStaffInvite: %v", err)
+				return
+			}
+		})
+
+		r.Put("/{adminId}", func(w http.ResponseWriter, r *http.Request) {
+			target, ok := getStaffFromURL(w, r, db)
+			if !ok {
+				return
+			}
+
+			role, boards, err := parseStaffForm(r, db)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			current, _ := getAdmin(r)
+			if status, err := checkCanManageStaff(db, current, target, role); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+
+			if err := database.UpdateAdminRole(db, target.Id, role, boards); err != nil {
+				http.Error(w, "Failed to update staff member", http.StatusInternalServerError)
+				log.Printf("UpdateAdminRole: %v", err)
+				return
+			}
+		})
+
+		r.Delete("/{adminId}", func(w http.ResponseWriter, r *http.Request) {
+			target, ok := getStaffFromURL(w, r, db)
+			if !ok {
+				return
+			}
+
+			current, _ := getAdmin(r)
+			if status, err := checkCanManageStaff(db, current, target, ""); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+
+			if err := database.DeleteAdmin(db, target); err != nil {
+				http.Error(w, "Failed to remove staff member", http.StatusInternalServerError)
+				log.Printf("DeleteAdmin: %v", err)
+				return
+			}
+		})
+	}
+}
+
+// the pages invite links lead to, where the invitee picks their login
+func staffInviteRoutes(db *sql.DB) func(r chi.Router) {
+	return func(r chi.Router) {
+		r.Get("/{token}", func(w http.ResponseWriter, r *http.Request) {
+			invite, err := database.GetStaffInvite(db, util.HashToken(chi.URLParam(r, "token")))
+			if err != nil {
+				if !errors.Is(err, database.ErrStaffInviteNotFound) {
+					log.Printf("GetStaffInvite: %v", err)
+				}
+				w.WriteHeader(http.StatusNotFound)
+				views.NotFound().Render(r.Context(), w)
+				return
+			}
+
+			admin.AcceptInvite(invite, r.URL.Path).Render(r.Context(), w)
+		})
+
+		r.Post("/{token}", func(w http.ResponseWriter, r *http.Request) {
+			invite, err := database.GetStaffInvite(db, util.HashToken(chi.URLParam(r, "token")))
+			if err != nil {
+				if errors.Is(err, database.ErrStaffInviteNotFound) {
+					http.Error(w, "This invite has expired or was already used", http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Failed to get invite", http.StatusInternalServerError)
+				log.Printf("GetStaffInvite: %v", err)
+				return
+			}
+
+			username := r.FormValue("username")
+			password := r.FormValue("password")
+
+			if err := util.ValidateStaffUsername(username); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(password) < util.MIN_STAFF_PASSWORD_LEN {
+				http.Error(w, fmt.Sprintf("Password must be at least %d characters", util.MIN_STAFF_PASSWORD_LEN), http.StatusBadRequest)
+				return
+			}
+
+			if _, err := database.GetAdmin(db, username); err == nil {
+				http.Error(w, "Username is taken", http.StatusBadRequest)
+				return
+			} else if !errors.Is(err, database.ErrAdminNotFound) {
+				http.Error(w, "Failed to get admin", http.StatusInternalServerError)
+				log.Printf("GetAdmin: %v", err)
+				return
+			}
+
+			passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+				log.Printf("GenerateFromPassword: %v", err)
+				return
+			}
+
+			if err := database.AcceptStaffInvite(db, invite, username, string(passwordHash)); err != nil {
+				if errors.Is(err, database.ErrStaffInviteNotFound) {
+					http.Error(w, "This invite has expired or was already used", http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Failed to create account", http.StatusInternalServerError)
+				log.Printf("AcceptStaffInvite: %v", err)
+				return
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/dominicf2001/comfychan/internal/database"
+	"github.com/dominicf2001/comfychan/internal/util"
+)
+
+// a migrated database in a temporary directory. it starts with the seeded
+// owner, whose id is 1
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCheckCanManageStaff(t *testing.T) {
+	staff := func(id int, role util.StaffRole) database.Admin {
+		return database.Admin{Id: id, Role: role}
+	}
+	seededOwner := staff(1, util.RoleOwner)
+
+	tests := []struct {
+		name       string
+		owners     int // owners in the database
+		current    database.Admin
+		target     database.Admin
+		newRole    util.StaffRole
+		wantStatus int
+	}{
+		{"own account", 1, seededOwner, seededOwner, util.RoleAdmin, http.StatusBadRequest},
+
+		{"owner promotes admin", 1, seededOwner, staff(2, util.RoleAdmin), util.RoleOwner, 0},
+		{"owner demotes mod", 1, seededOwner, staff(2, util.RoleMod), util.RoleJanitor, 0},
+		{"owner removes janitor", 1, seededOwner, staff(2, util.RoleJanitor), "", 0},
+		{"owner demotes another owner", 2, seededOwner, staff(2, util.RoleOwner), util.RoleAdmin, 0},
+		{"owner removes another owner", 2, seededOwner, staff(2, util.RoleOwner), "", 0},
+		{"owner keeps owner role", 1, staff(2, util.RoleOwner), seededOwner, util.RoleOwner, 0},
+
+		{"admin edits owner", 1, staff(2, util.RoleAdmin), seededOwner, util.RoleAdmin, http.StatusForbidden},
+		{"admin edits admin", 1, staff(2, util.RoleAdmin), staff(3, util.RoleAdmin), util.RoleMod, http.StatusForbidden},
+		{"admin promotes mod to admin", 1, staff(2, util.RoleAdmin), staff(3, util.RoleMod), util.RoleAdmin, http.StatusForbidden},
+		{"admin promotes janitor to owner", 1, staff(2, util.RoleAdmin), staff(3, util.RoleJanitor), util.RoleOwner, http.StatusForbidden},
+		{"admin promotes janitor to mod", 1, staff(2, util.RoleAdmin), staff(3, util.RoleJanitor), util.RoleMod, 0},
+		{"admin removes mod", 1, staff(2, util.RoleAdmin), staff(3, util.RoleMod), "", 0},
+
+		{"mod edits janitor", 1, staff(2, util.RoleMod), staff(3, util.RoleJanitor), util.RoleJanitor, http.StatusForbidden},
+		{"mod removes janitor", 1, staff(2, util.RoleMod), staff(3, util.RoleJanitor), "", http.StatusForbidden},
+		{"janitor removes janitor", 1, staff(2, util.RoleJanitor), staff(3, util.RoleJanitor), "", http.StatusForbidden},
+
+		// the current owner's own row may already have been demoted by
+		// another owner, so the count is what decides
+		{"last owner demoted", 1, staff(2, util.RoleOwner), seededOwner, util.RoleAdmin, http.StatusBadRequest},
+		{"last owner removed", 1, staff(2, util.RoleOwner), seededOwner, "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			for i := 1; i < tt.owners; i++ {
+				_, err := db.Exec(`INSERT INTO admins (username, password, role) VALUES (?, '', ?)`,
+					"owner"+string(rune('a'+i)), util.RoleOwner)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			status, err := checkCanManageStaff(db, tt.current, tt.target, tt.newRole)
+			if status != tt.wantStatus {
+				t.Errorf("checkCanManageStaff() = %d, %v, want status %d", status, err, tt.wantStatus)
+			}
+			if (err != nil) != (tt.wantStatus != 0) {
+				t.Errorf("checkCanManageStaff() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
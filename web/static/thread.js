@@ -2,6 +2,8 @@ function insertHeaderReplies() {
     const posts = Array.from(document.querySelectorAll("article"));
     const replies = {};
 
+    document.querySelectorAll(".post-replies").forEach(el => el.replaceChildren());
+
     for (const post of posts) {
         const postId = +post.id.split("-")[1];
         const postBody = post.querySelector("p").textContent;
@@ -42,41 +44,65 @@ function togglePostFile(el) {
         el.addEventListener(readyEvt, () => el.classList.remove('post-file-loading'), { once: true });
     }
 
-    const imgEl = el.parentElement.parentElement.querySelector("img");
-    const isVideo = imgEl.dataset.full.endsWith(".mp4") || imgEl.dataset.full.endsWith(".webm") || imgEl.dataset.full.endsWith(".ogg");
+    const fileEl = el.closest(".post-file");
+    const imgEl = fileEl.querySelector("img");
+
+    // opening a spoilered file reveals its thumbnail for good
+    if (imgEl.classList.contains("post-img-spoiler")) {
+        imgEl.classList.remove("post-img-spoiler");
+        imgEl.src = imgEl.dataset.thumb;
+    }
+    const isPlayable = imgEl.dataset.type === "video" || imgEl.dataset.type === "audio";
 
-    if (isVideo) {
-        const vidEl = imgEl.parentElement.querySelector("video");
+    if (isPlayable) {
+        const vidEl = fileEl.querySelector("video, audio");
 
-        const closeVidBtn = imgEl.parentElement.querySelector(".link-button");
+        // hide the duration overlay along with the thumbnail
+        const thumbEl = imgEl.closest(".post-thumb") ?? imgEl;
+        const closeVidBtn = fileEl.querySelector(".link-button");
         if (vidEl.style.display === "none") {
-            vidEl.src = "/media/posts/full/" + imgEl.dataset.full;
+            vidEl.src = imgEl.dataset.full;
             makeOpaqueUntilReady(vidEl, 'canplay');
             vidEl.style.display = "";
-            imgEl.style.display = "none";
+            thumbEl.style.display = "none";
             closeVidBtn.style.display = "";
         }
         else {
             vidEl.src = "";
             vidEl.style.display = "none";
-            imgEl.style.display = "";
+            thumbEl.style.display = "";
             closeVidBtn.style.display = "none";
         }
     }
     else {
         if (imgEl.classList.contains("post-img-full")) {
             imgEl.classList.remove("post-img-full");
-            imgEl.src = "/media/posts/thumb/" + imgEl.dataset.thumb;
+            imgEl.src = imgEl.dataset.thumb;
         }
         else {
             imgEl.classList.add("post-img-full");
-            imgEl.src = "/media/posts/full/" + imgEl.dataset.full;
+            imgEl.src = imgEl.dataset.full;
             if (!imgEl.complete)
                 makeOpaqueUntilReady(imgEl, 'load');
         }
     }
 }
 
+function togglePostPreview(imgEl, show) {
+    if (imgEl.classList.contains("post-img-full") || imgEl.classList.contains("post-img-spoiler")) return;
+    imgEl.src = show ? imgEl.dataset.preview : imgEl.dataset.thumb;
+}
+
+function togglePosterIdHighlight(posterId) {
+    const idEls = document.querySelectorAll(`.post-id[data-posterid="${posterId}"]`);
+    const shouldHighlight = !idEls[0]?.closest("article").classList.contains("post-id-highlighted");
+
+    document.querySelectorAll(".post-id-highlighted").forEach(el => el.classList.remove("post-id-highlighted"));
+    if (!shouldHighlight) return;
+
+    idEls.forEach(el => el.closest("article").classList.add("post-id-highlighted"));
+}
+
 function initializePosts() {
     // set dates to correct timezone
     document.querySelectorAll('.post-datetime').forEach(el => {
@@ -97,4 +123,48 @@ function initializePosts() {
     insertHeaderReplies()
 }
 
+function subscribeThreadEvents(url) {
+    const source = new EventSource(url);
+
+    source.addEventListener("post-created", (e) => {
+        const template = document.createElement("template");
+        template.innerHTML = e.data;
+        const post = template.content.querySelector("article");
+        if (!post || $(post.id)) return;
+
+        const repliesContainer = $("threadReplies");
+        const shouldScroll = window.innerHeight + window.scrollY >= document.body.scrollHeight - 50;
+
+        repliesContainer.append(post, document.createElement("br"));
+        htmx.process(post);
+        _hyperscript.processNode(post);
+        initializePosts();
+
+        if (shouldScroll) smoothScrollTo("bottom");
+    });
+
+    source.addEventListener("post-deleted", (e) => {
+        const post = $(`post-${e.data}`);
+        if (!post) return;
+
+        if (post.nextElementSibling?.tagName === "BR") post.nextElementSibling.remove();
+        post.remove();
+        initializePosts();
+    });
+
+    source.addEventListener("thread-updated", () => {
+        htmx.trigger(document.body, "refreshPosts");
+    });
+
+    for (const type of ["thread-deleted", "thread-archived"]) {
+        source.addEventListener(type, () => {
+            source.close();
+            window.location.reload();
+        });
+    }
+}
+
 initializePosts();
+
+const threadEl = document.querySelector(".thread[data-events-url]");
+if (threadEl) subscribeThreadEvents(threadEl.dataset.eventsUrl);